@@ -11,6 +11,7 @@ import (
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jeelabs/jet/glow/broker"
 	"github.com/mitchellh/mapstructure"
 )
 
@@ -25,6 +26,7 @@ func main() {
 	decodeFlag := flag.Bool("dv", false, "decode varints in displayed messages")
 	dataStore := flag.String("data", "store.db", "data store file name & path")
 	mqttPort := flag.String("mqtt", "tcp://localhost:1883", "MQTT server port")
+	brokerAddr := flag.String("broker", "", "run an embedded MQTT broker, e.g. :1883")
 	loggerDir := flag.String("logger", "logger", "dir path for logger files")
 	packsDir := flag.String("packs", "packs", "location of all pack scripts")
 	flag.Parse()
@@ -50,6 +52,18 @@ func main() {
 	log.Println("[JET/Hub] " + version)
 	//log.Println("args:", os.Args[1:])
 
+	// start the embedded MQTT broker, if requested, and connect to it
+	if *brokerAddr != "" {
+		b, err := broker.Listen(*brokerAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer b.Close()
+		log.Println("embedded MQTT broker at", b.Addr())
+		*mqttPort = b.URL()
+		os.Setenv("HUB_MQTT", *mqttPort)
+	}
+
 	// connect to MQTT and wait for it before doing anything else
 	hubStatus := connectToHub("hub", *mqttPort, true)
 	defer hub.Disconnect(250)
@@ -0,0 +1,388 @@
+// The broker package is a small MQTT 3.1.1 broker, which can be embedded in
+// an application or started on a random port in tests. It supports retained
+// messages, wildcards, QoS 0 and 1, and last wills, but no persistent sessions:
+// QoS 2 is downgraded to 1, and unacknowledged messages are not resent.
+package broker
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// A Broker accepts MQTT clients on a network listener.
+type Broker struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	clients  map[string]*client
+	retained map[string]*packets.PublishPacket
+	closed   bool
+}
+
+// Listen starts a broker on a TCP address, e.g. ":1883", or "127.0.0.1:0" to
+// pick a free port.
+func Listen(addr string) (*Broker, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		ln:       ln,
+		conns:    map[net.Conn]bool{},
+		clients:  map[string]*client{},
+		retained: map[string]*packets.PublishPacket{},
+	}
+	b.wg.Add(1)
+	go b.serve()
+	return b, nil
+}
+
+// Addr returns the address the broker is listening on.
+func (b *Broker) Addr() net.Addr {
+	return b.ln.Addr()
+}
+
+// URL returns the address of the broker in the form used by MQTT clients.
+// When listening on all interfaces, it refers to the local host.
+func (b *Broker) URL() string {
+	addr := b.ln.Addr().(*net.TCPAddr)
+	host := "localhost"
+	if !addr.IP.IsUnspecified() {
+		host = addr.IP.String()
+	}
+	return "tcp://" + net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// Close stops accepting clients, drops all connections, and waits for them
+// to finish. Last wills are not sent.
+func (b *Broker) Close() error {
+	err := b.ln.Close()
+	b.mu.Lock()
+	b.closed = true
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+// Subscribers returns the QoS granted to each client which is subscribed to a
+// topic filter, by client id.
+func (b *Broker) Subscribers(filter string) map[string]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := map[string]byte{}
+	for id, c := range b.clients {
+		if qos, ok := c.subs[filter]; ok {
+			m[id] = qos
+		}
+	}
+	return m
+}
+
+// serve accepts new connections until the listener is closed.
+func (b *Broker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = true
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			c := &client{b: b, conn: conn, subs: map[string]byte{}}
+			c.serve()
+			b.mu.Lock()
+			delete(b.conns, conn)
+			b.mu.Unlock()
+		}()
+	}
+}
+
+// publish sends a message to all matching subscribers, and keeps it if it
+// is to be retained. An empty retained message clears the retained one.
+func (b *Broker) publish(p *packets.PublishPacket) {
+	b.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			r := p.Copy()
+			r.Qos, r.Retain = p.Qos, true
+			b.retained[p.TopicName] = r
+		}
+	}
+	type delivery struct {
+		c   *client
+		qos byte
+	}
+	var v []delivery
+	for _, c := range b.clients {
+		if qos, ok := c.matches(p.TopicName); ok {
+			v = append(v, delivery{c, qos})
+		}
+	}
+	b.mu.Unlock()
+
+	for _, d := range v {
+		d.c.send(p, d.qos, false)
+	}
+}
+
+// A client is a connection to the broker. Its subscriptions map topic filters
+// to the granted QoS, and are protected by the broker's mutex.
+type client struct {
+	b    *Broker
+	conn net.Conn
+	id   string
+	will *packets.PublishPacket
+	subs map[string]byte
+
+	wmu    sync.Mutex // serialises writes
+	nextID uint16
+}
+
+// serve handles the connection, from CONNECT until it is closed.
+func (c *client) serve() {
+	defer c.conn.Close()
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	p, err := packets.ReadPacket(c.conn)
+	if err != nil {
+		return
+	}
+	cp, ok := p.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	if !c.connect(cp) {
+		return
+	}
+	defer c.disconnect()
+
+	keepalive := time.Duration(cp.Keepalive) * time.Second * 3 / 2
+	for {
+		if keepalive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(keepalive))
+		} else {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		p, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.PublishPacket:
+			switch p.Qos {
+			case 1:
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			case 2:
+				ack := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			}
+			c.b.publish(p)
+		case *packets.PubrelPacket:
+			ack := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			ack.MessageID = p.MessageID
+			c.write(ack)
+		case *packets.SubscribePacket:
+			c.subscribe(p)
+		case *packets.UnsubscribePacket:
+			c.b.mu.Lock()
+			for _, t := range p.Topics {
+				delete(c.subs, t)
+			}
+			c.b.mu.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			c.write(ack)
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			c.will = nil
+			return
+		}
+	}
+}
+
+// connect registers the client, replacing an existing one with the same id.
+// It returns false if the connection has been refused.
+func (c *client) connect(cp *packets.ConnectPacket) bool {
+	ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ack.ReturnCode = cp.Validate()
+	if ack.ReturnCode != packets.Accepted {
+		c.write(ack)
+		return false
+	}
+
+	c.id = cp.ClientIdentifier
+	if c.id == "" {
+		c.id = c.conn.RemoteAddr().String()
+	}
+	if cp.WillFlag {
+		c.will = packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		c.will.TopicName = cp.WillTopic
+		c.will.Payload = cp.WillMessage
+		c.will.Qos, c.will.Retain = cp.WillQos, cp.WillRetain
+	}
+
+	c.b.mu.Lock()
+	old := c.b.clients[c.id]
+	c.b.clients[c.id] = c
+	c.b.mu.Unlock()
+	if old != nil {
+		old.conn.Close()
+	}
+	return c.write(ack) == nil
+}
+
+// disconnect unregisters the client, and sends its will if it has one and
+// the broker is not shutting down.
+func (c *client) disconnect() {
+	c.b.mu.Lock()
+	if c.b.clients[c.id] == c {
+		delete(c.b.clients, c.id)
+	}
+	closed := c.b.closed
+	c.b.mu.Unlock()
+	if c.will != nil && !closed {
+		c.b.publish(c.will)
+	}
+}
+
+// subscribe adds topic filters, and sends the retained messages they match.
+func (c *client) subscribe(p *packets.SubscribePacket) {
+	ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	ack.MessageID = p.MessageID
+	c.b.mu.Lock()
+	for i, t := range p.Topics {
+		qos := p.Qoss[i]
+		if qos > 1 {
+			qos = 1
+		}
+		if validFilter(t) {
+			c.subs[t] = qos
+		} else {
+			qos = 0x80 // failure
+		}
+		ack.ReturnCodes = append(ack.ReturnCodes, qos)
+	}
+	type delivery struct {
+		p   *packets.PublishPacket
+		qos byte
+	}
+	var v []delivery
+	for _, r := range c.b.retained {
+		for _, t := range p.Topics {
+			if Match(t, r.TopicName) {
+				qos, _ := c.matches(r.TopicName)
+				v = append(v, delivery{r, qos})
+				break
+			}
+		}
+	}
+	c.b.mu.Unlock()
+
+	c.write(ack)
+	for _, d := range v {
+		c.send(d.p, d.qos, true)
+	}
+}
+
+// matches returns the highest QoS of all subscriptions matching a topic.
+func (c *client) matches(topic string) (qos byte, ok bool) {
+	for f, q := range c.subs {
+		if Match(f, topic) {
+			if !ok || q > qos {
+				qos = q
+			}
+			ok = true
+		}
+	}
+	return
+}
+
+// send delivers a message, at the lower of its own and the granted QoS.
+func (c *client) send(p *packets.PublishPacket, qos byte, retain bool) {
+	out := p.Copy()
+	out.Qos, out.Retain = p.Qos, retain
+	if qos < out.Qos {
+		out.Qos = qos
+	}
+	if out.Qos > 1 {
+		out.Qos = 1
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if out.Qos > 0 {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		out.MessageID = c.nextID
+	}
+	if out.Write(c.conn) != nil {
+		c.conn.Close()
+	}
+}
+
+// write sends a packet to the client.
+func (c *client) write(p packets.ControlPacket) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return p.Write(c.conn)
+}
+
+// validFilter checks that wildcards only occupy entire levels, and that "#"
+// can only be the last level.
+func validFilter(filter string) bool {
+	levels := strings.Split(filter, "/")
+	for i, s := range levels {
+		if strings.ContainsAny(s, "+#") && len(s) > 1 ||
+			s == "#" && i < len(levels)-1 {
+			return false
+		}
+	}
+	return filter != ""
+}
+
+// Match returns true if a topic matches a filter with MQTT-style wildcards.
+// As required by MQTT, topics starting with "$" only match filters which
+// don't start with a wildcard.
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && strings.IndexAny(filter, "+#") == 0 {
+		return false
+	}
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		switch {
+		case f == "#":
+			return true
+		case i >= len(ts):
+			return false
+		case f != "+" && f != ts[i]:
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
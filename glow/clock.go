@@ -0,0 +1,83 @@
+package glow
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A Clock is the source of time for all timers, in milliseconds.
+type Clock interface {
+	// Now returns the current time.
+	Now() int
+	// Sleep waits until time t has been reached, or until the context is done
+	// or something arrives on the wake channel. If t is negative, there is no
+	// timeout at all. Simulated clocks jump ahead instead of waiting.
+	Sleep(ctx context.Context, t int, wake <-chan struct{})
+}
+
+// A SimClock is a simulated clock. Time only moves forward when there are
+// timers to fire or when Run is called, and it never waits.
+type SimClock struct {
+	mu sync.Mutex
+	t  int
+}
+
+// NewSimClock returns a simulated clock, starting at zero.
+func NewSimClock() *SimClock {
+	return &SimClock{}
+}
+
+// Now returns the current simulated time.
+func (sc *SimClock) Now() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.t
+}
+
+// Sleep jumps ahead to time t right away, or waits for a new timer if t < 0.
+func (sc *SimClock) Sleep(ctx context.Context, t int, wake <-chan struct{}) {
+	if t < 0 {
+		select {
+		case <-wake:
+		case <-ctx.Done():
+		}
+		return
+	}
+	sc.mu.Lock()
+	if t > sc.t {
+		sc.t = t
+	}
+	sc.mu.Unlock()
+}
+
+// RealClock follows the wall clock, starting at zero when it is created.
+type RealClock struct {
+	start time.Time
+}
+
+// NewRealClock returns a clock which runs in real time.
+func NewRealClock() *RealClock {
+	return &RealClock{start: time.Now()}
+}
+
+// Now returns the number of milliseconds since the clock was created.
+func (rc *RealClock) Now() int {
+	return int(time.Since(rc.start) / time.Millisecond)
+}
+
+// Sleep waits until time t, or a wakeup or the context being done.
+func (rc *RealClock) Sleep(ctx context.Context, t int, wake <-chan struct{}) {
+	var timeout <-chan time.Time
+	if t >= 0 {
+		d := rc.start.Add(time.Duration(t) * time.Millisecond).Sub(time.Now())
+		tm := time.NewTimer(d)
+		defer tm.Stop()
+		timeout = tm.C
+	}
+	select {
+	case <-timeout:
+	case <-wake:
+	case <-ctx.Done():
+	}
+}
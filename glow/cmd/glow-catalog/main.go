@@ -0,0 +1,20 @@
+// Glow-catalog dumps the descriptions of all the registered gadget types as
+// JSON, e.g. for the palette of the web editor.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func main() {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(glow.Specs()); err != nil {
+		log.Fatal(err)
+	}
+}
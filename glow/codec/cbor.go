@@ -0,0 +1,237 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+// CBOR is a compact binary encoding, using the subset of RFC 7049 needed to
+// represent messages: ints, float64s, bools, null, text, arrays, and maps.
+var CBOR Codec = cborCodec{}
+
+type cborCodec struct{}
+
+// CBOR major types, stored in the top 3 bits of each initial byte.
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborSimple = 7 << 5
+)
+
+// CBOR simple values and floats (major type 7).
+const (
+	cborFalse   = cborSimple | 20
+	cborTrue    = cborSimple | 21
+	cborNull    = cborSimple | 22
+	cborFloat32 = cborSimple | 26
+	cborFloat64 = cborSimple | 27
+)
+
+// Marshal encodes a message as a CBOR array, a bang becomes an empty array.
+func (cborCodec) Marshal(m glow.Message) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeCBOR(&b, m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Unmarshal decodes CBOR, a value other than an array becomes a message with
+// that value as its only element.
+func (cborCodec) Unmarshal(data []byte) (glow.Message, error) {
+	r := &cborReader{data: data}
+	v, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("codec: trailing data after CBOR value")
+	}
+	if m, ok := v.(glow.Message); ok {
+		return m, nil
+	}
+	return glow.Message{v}, nil
+}
+
+// writeHead emits the initial byte(s) with the shortest possible argument.
+func writeHead(b *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		b.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		b.WriteByte(major | 24)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(major | 25)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		b.WriteByte(major | 26)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	default:
+		b.WriteByte(major | 27)
+		binary.Write(b, binary.BigEndian, n)
+	}
+}
+
+// writeCBOR generates the binary encoding for a single value.
+func writeCBOR(b *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		b.WriteByte(cborNull)
+	case int:
+		if x >= 0 {
+			writeHead(b, cborUint, uint64(x))
+		} else {
+			writeHead(b, cborNegint, uint64(-1-x))
+		}
+	case float64:
+		b.WriteByte(cborFloat64)
+		binary.Write(b, binary.BigEndian, math.Float64bits(x))
+	case bool:
+		if x {
+			b.WriteByte(cborTrue)
+		} else {
+			b.WriteByte(cborFalse)
+		}
+	case string:
+		writeHead(b, cborText, uint64(len(x)))
+		b.WriteString(x)
+	case glow.Message:
+		writeHead(b, cborArray, uint64(len(x)))
+		for _, e := range x {
+			if err := writeCBOR(b, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeHead(b, cborMap, uint64(len(x)))
+		for _, k := range keys {
+			writeHead(b, cborText, uint64(len(k)))
+			b.WriteString(k)
+			if err := writeCBOR(b, x[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return unsupported(v)
+	}
+	return nil
+}
+
+// A cborReader decodes values from a byte slice.
+type cborReader struct {
+	data []byte
+	pos  int
+}
+
+var errShort = fmt.Errorf("codec: unexpected end of CBOR data")
+
+// next returns the next n bytes of input.
+func (r *cborReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errShort
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// readHead decodes an initial byte and its argument.
+func (r *cborReader) readHead() (major, info byte, n uint64, err error) {
+	b, err := r.next(1)
+	if err != nil {
+		return
+	}
+	major, info = b[0]&0xE0, b[0]&0x1F
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		b, err = r.next(1 << (info - 24))
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+	default:
+		err = fmt.Errorf("codec: unsupported CBOR item 0x%02X", major|info)
+	}
+	return
+}
+
+// read decodes one complete value.
+func (r *cborReader) read() (interface{}, error) {
+	major, info, n, err := r.readHead()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			return float64(n), nil
+		}
+		return int(n), nil
+	case cborNegint:
+		if n > math.MaxInt64 {
+			return -1 - float64(n), nil
+		}
+		return -1 - int(n), nil
+	case cborBytes, cborText:
+		b, err := r.next(n)
+		return string(b), err
+	case cborArray:
+		m := glow.Message{}
+		for i := uint64(0); i < n; i++ {
+			v, err := r.read()
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, v)
+		}
+		return m, nil
+	case cborMap:
+		mv := map[string]interface{}{}
+		for i := uint64(0); i < n; i++ {
+			k, err := r.read()
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.read()
+			if err != nil {
+				return nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				ks = glow.Message{k}.String()
+			}
+			mv[ks] = v
+		}
+		return mv, nil
+	case cborSimple:
+		switch major | info {
+		case cborFalse:
+			return false, nil
+		case cborTrue:
+			return true, nil
+		case cborNull:
+			return nil, nil
+		case cborFloat32:
+			return float64(math.Float32frombits(uint32(n))), nil
+		case cborFloat64:
+			return math.Float64frombits(n), nil
+		}
+	}
+	return nil, fmt.Errorf("codec: unsupported CBOR item 0x%02X", major|info)
+}
@@ -0,0 +1,27 @@
+// The codec package converts glow messages to and from a serialised form.
+// Both encodings preserve the full message structure, including nested
+// vectors, bangs, maps, and the distinction between ints and floats.
+package codec
+
+import (
+	"fmt"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+// A Codec marshals messages to bytes and back again.
+type Codec interface {
+	Marshal(m glow.Message) ([]byte, error)
+	Unmarshal(data []byte) (glow.Message, error)
+}
+
+// The Registry is a collection of named codecs.
+var Registry = map[string]Codec{
+	"json": JSON,
+	"cbor": CBOR,
+}
+
+// unsupported reports a value which cannot be represented in a message.
+func unsupported(v interface{}) error {
+	return fmt.Errorf("codec: unsupported type %T", v)
+}
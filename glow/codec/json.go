@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+// JSON maps messages onto JSON, with vectors as arrays and maps as objects.
+// Floats always include a decimal point or exponent so they stay floats, and
+// strings must be valid UTF-8.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+// Marshal encodes a message as a JSON array, a bang becomes an empty array.
+func (jsonCodec) Marshal(m glow.Message) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeJSON(&b, m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Unmarshal decodes JSON, a value other than an array becomes a message with
+// that value as its only element.
+func (jsonCodec) Unmarshal(data []byte) (glow.Message, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, fmt.Errorf("codec: trailing data after JSON value")
+	}
+	x, err := fromJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := x.(glow.Message); ok {
+		return m, nil
+	}
+	return glow.Message{x}, nil
+}
+
+// writeJSON generates JSON text for a single value.
+func writeJSON(b *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case int:
+		b.WriteString(strconv.Itoa(x))
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return fmt.Errorf("codec: cannot represent %g in JSON", x)
+		}
+		s := strconv.FormatFloat(x, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		b.WriteString(s)
+	case bool:
+		b.WriteString(strconv.FormatBool(x))
+	case string:
+		s, _ := json.Marshal(x)
+		b.Write(s)
+	case glow.Message:
+		b.WriteByte('[')
+		for i, e := range x {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeJSON(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			s, _ := json.Marshal(k)
+			b.Write(s)
+			b.WriteByte(':')
+			if err := writeJSON(b, x[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	default:
+		return unsupported(v)
+	}
+	return nil
+}
+
+// fromJSON converts the result of a generic JSON decode to message values.
+func fromJSON(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case json.Number:
+		if n, err := strconv.Atoi(string(x)); err == nil {
+			return n, nil
+		}
+		return x.Float64()
+	case []interface{}:
+		m := glow.Message{}
+		for _, e := range x {
+			y, err := fromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, y)
+		}
+		return m, nil
+	case map[string]interface{}:
+		for k, e := range x {
+			y, err := fromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			x[k] = y
+		}
+		return x, nil
+	}
+	return v, nil
+}
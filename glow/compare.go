@@ -0,0 +1,140 @@
+package glow
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Equal returns true if two messages have the same structure and contents.
+// Ints and floats are equal if they have the same value.
+func (m Message) Equal(o Message) bool {
+	return m.Compare(o) == 0
+}
+
+// Compare returns -1, 0, or +1 when m sorts before, the same as, or after o.
+// Messages are compared element by element, with nested vectors compared in
+// the same way, and a message which is a prefix of the other sorts first,
+// i.e. a bang sorts before anything else. A nested bang can be either nil or
+// an empty message, these are equal. Elements of different types sort as
+// nil, bool, number, string, vector, and map. False sorts before true, NaN
+// before all other numbers, and maps are compared as vectors of sorted keys,
+// each followed by its value. Elements of any other type, e.g. []byte, sort
+// last, by the name of their type and then by their formatted value.
+func (m Message) Compare(o Message) int {
+	for i := 0; i < len(m) && i < len(o); i++ {
+		if c := compareElements(m[i], o[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(m), len(o))
+}
+
+// compareElements compares two message elements, as described for Compare.
+func compareElements(a, b interface{}) int {
+	a, b = bangAsNil(a), bangAsNil(b)
+	ra, rb := elementRank(a), elementRank(b)
+	if ra != rb {
+		return compareInts(ra, rb)
+	}
+	switch x := a.(type) {
+	case int:
+		if y, ok := b.(int); ok {
+			return compareInts(x, y) // exact, even beyond 2^53
+		}
+	case bool:
+		y := b.(bool)
+		return compareInts(boolRank(x), boolRank(y))
+	case string:
+		return compareStrings(x, b.(string))
+	case Message:
+		return x.Compare(b.(Message))
+	case map[string]interface{}:
+		return mapVector(x).Compare(mapVector(b.(map[string]interface{})))
+	}
+	if ra == 2 {
+		return compareFloats(Message{a}.AsFloat(), Message{b}.AsFloat())
+	}
+	if c := compareStrings(fmt.Sprintf("%T", a), fmt.Sprintf("%T", b)); c != 0 {
+		return c
+	}
+	return compareStrings(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+// bangAsNil turns a nested empty message into nil, since both are a bang.
+func bangAsNil(v interface{}) interface{} {
+	if m, ok := v.(Message); ok && len(m) == 0 {
+		return nil
+	}
+	return v
+}
+
+// elementRank orders the types of message elements.
+func elementRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int, float64:
+		return 2
+	case string:
+		return 3
+	case Message:
+		return 4
+	case map[string]interface{}:
+		return 5
+	}
+	return 6
+}
+
+// mapVector turns a map into a vector of its sorted keys and their values.
+func mapVector(mv map[string]interface{}) Message {
+	keys := make([]string, 0, len(mv))
+	for k := range mv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	v := make(Message, 0, 2*len(keys))
+	for _, k := range keys {
+		v = append(v, k, mv[k])
+	}
+	return v
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return compareInts(boolRank(!math.IsNaN(a)), boolRank(!math.IsNaN(b)))
+}
@@ -0,0 +1,127 @@
+package glow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DesignPath lists the directories where designs are looked up by name. A
+// design "foo" is loaded from file "foo.pd", and can then be used as gadget,
+// in the same way as an abstraction in Pd.
+var DesignPath []string
+
+// designs caches the parsed records of each design file which has been used.
+var designs = struct {
+	sync.Mutex
+	recs map[string][]pdRecord
+}{recs: map[string][]pdRecord{}}
+
+// maxDesignDepth is the deepest nesting of designs which will be loaded, to
+// catch designs which (indirectly) use themselves.
+const maxDesignDepth = 50
+
+// lookupDesign finds a design by name, and instantiates it as a circuit with
+// "$1".."$n" replaced by the args. The depth is the number of designs it is
+// nested in. It returns nil if there is no design, or if it can't be loaded.
+// The problems found in the design are returned as well, with its path.
+func lookupDesign(name string, args Message, depth int) (*Circuit, DesignError) {
+	path, recs, err := findDesign(name)
+	if recs == nil && err == nil {
+		return nil, nil
+	}
+	if err == nil && depth > maxDesignDepth {
+		err = fmt.Errorf("designs nested too deeply")
+	}
+	if err != nil {
+		return nil, DesignError{fmt.Errorf("%s: %v", path, err)}
+	}
+
+	inst := make([]pdRecord, len(recs))
+	for i, r := range recs {
+		inst[i] = r
+		if r.atoms.At(0).AsString() == "#X" && r.atoms.At(1).AsString() == "obj" {
+			inst[i].atoms = append(r.atoms[:4:4], r.atoms[4:].Substitute(args)...)
+		}
+	}
+	c, errs := loadPd(inst, depth+1)
+	for i, err := range errs {
+		errs[i] = fmt.Errorf("%s: %v", path, err)
+	}
+	return c, errs
+}
+
+// findDesign returns the parsed records of a design, from the cache if
+// possible. The records are nil if the design can't be found.
+func findDesign(name string) (string, []pdRecord, error) {
+	for _, dir := range DesignPath {
+		path := filepath.Join(dir, name+".pd")
+		designs.Lock()
+		recs, ok := designs.recs[path]
+		designs.Unlock()
+		if ok {
+			return path, recs, nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return path, nil, err
+		}
+		recs = parsePd(string(data))
+		designs.Lock()
+		designs.recs[path] = recs
+		designs.Unlock()
+		return path, recs, nil
+	}
+	return "", nil, nil
+}
+
+// Substitute returns a copy of m with each "$1".."$n" replaced by the
+// corresponding arg, or by 0 if there is no such arg, as in Pd. An element
+// which consists of just one "$n" is replaced by the arg itself, so that its
+// type is preserved. "$0" is left as is, Send and Receive expand it.
+func (m Message) Substitute(args Message) Message {
+	r := make(Message, len(m))
+	for i, a := range m {
+		r[i] = substitute(a, args)
+	}
+	return r
+}
+
+// substitute replaces "$1".."$n" in one atom, see Message.Substitute.
+func substitute(atom interface{}, args Message) interface{} {
+	s, ok := atom.(string)
+	if !ok || !strings.Contains(s, "$") {
+		return atom
+	}
+	arg := func(n int) interface{} {
+		if n > len(args) {
+			return 0
+		}
+		return args[n-1]
+	}
+	if n, err := strconv.Atoi(s[1:]); s[0] == '$' && err == nil && n > 0 {
+		return arg(n)
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		j := i + 1
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		n, _ := strconv.Atoi(s[i+1 : j])
+		if s[i] != '$' || n == 0 {
+			b.WriteByte(s[i])
+			continue
+		}
+		b.WriteString(Message{arg(n)}.String())
+		i = j - 1
+	}
+	return b.String()
+}
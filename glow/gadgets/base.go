@@ -7,140 +7,267 @@ import (
 )
 
 func init() {
-	glow.Registry["print"] = func(args glow.Message) glow.Gadgetry {
-		g := glow.NewGadget()
-		g.AddInlet(func(m glow.Message) {
-			if args.IsBang() {
-				fmt.Fprintln(glow.Debug, m)
-			} else {
-				fmt.Fprintln(glow.Debug, args, m)
+	glow.Register(glow.Spec{
+		Name:   "print",
+		Doc:    "Prints incoming messages on Debug, after the args if there are any.",
+		Args:   []glow.Param{param("label", "any", "")},
+		Inlets: []glow.Param{param("in", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			g.AddInlet(func(m glow.Message) {
+				if args.IsBang() {
+					fmt.Fprintln(glow.Debug, m)
+				} else {
+					fmt.Fprintln(glow.Debug, args, m)
+				}
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name:    "pass",
+		Doc:     "Passes all messages on unchanged.",
+		Inlets:  []glow.Param{param("in", "any", "")},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				g.Emit(0, m)
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name:    "inlet",
+		Doc:     "Adds an inlet to the circuit, and emits what arrives on it.",
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.OnAdded = func(c *glow.Circuit) {
+				c.AddInlet(func(m glow.Message) {
+					g.Emit(0, m)
+				})
 			}
-		})
-		return g
-	}
+			return g
+		},
+	})
 
-	glow.Registry["pass"] = func(args glow.Message) glow.Gadgetry {
-		g := glow.NewGadget()
-		g.AddOutlets(1)
-		g.AddInlet(func(m glow.Message) {
-			g.Emit(0, m)
-		})
-		return g
-	}
+	glow.Register(glow.Spec{
+		Name:   "outlet",
+		Doc:    "Adds an outlet to the circuit, and sends what arrives to it.",
+		Inlets: []glow.Param{param("in", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			g.OnAdded = func(c *glow.Circuit) {
+				o := c.AddOutlets(1)
+				g.AddInlet(func(m glow.Message) {
+					c.Emit(o, m)
+				})
+			}
+			return g
+		},
+	})
 
-	glow.Registry["inlet"] = func(args glow.Message) glow.Gadgetry {
-		g := glow.NewGadget()
-		g.AddOutlets(1)
-		g.OnAdded = func(c *glow.Circuit) {
-			c.AddInlet(func(m glow.Message) {
-				g.Emit(0, m)
+	glow.Register(glow.Spec{
+		Name: "swap",
+		Doc:  "Emits the incoming message on the right and the stored value on the left.",
+		Args: []glow.Param{param("value", "any", "the initial value")},
+		Inlets: []glow.Param{
+			param("in", "any", ""),
+			cold("value", "any", ""),
+		},
+		Outlets: []glow.Param{
+			param("value", "any", ""),
+			param("in", "any", ""),
+		},
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			g.AddOutlets(2)
+			g.AddInlet(func(m glow.Message) {
+				g.Emit(1, m)
+				g.Emit(0, args)
 			})
-		}
-		return g
-	}
+			g.AddColdInlet(func(m glow.Message) {
+				args = m
+			})
+			return g
+		},
+	})
 
-	glow.Registry["outlet"] = func(args glow.Message) glow.Gadgetry {
-		g := glow.NewGadget()
-		g.OnAdded = func(c *glow.Circuit) {
-			o := c.AddOutlets(1)
+	glow.Register(glow.Spec{
+		Name:    "send",
+		Aliases: []string{"s"},
+		Doc:     "Sends messages to all receivers with the same name.",
+		Args: []glow.Param{
+			param("name", "string", "$0 is replaced by a unique id per design instance"),
+			param("scope", "string", "global (default), local, or up"),
+		},
+		Inlets: []glow.Param{param("in", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			name, scope := scopedName(args)
+			g := glow.NewGadget()
 			g.AddInlet(func(m glow.Message) {
-				c.Emit(o, m)
+				if c := g.Parent(); c != nil { // dropped outside a circuit
+					c.Send(name, scope, m)
+				}
 			})
-		}
-		return g
-	}
+			return g
+		},
+	})
 
-	glow.Registry["swap"] = func(args glow.Message) glow.Gadgetry {
-		g := glow.NewGadget()
-		g.AddOutlets(2)
-		g.AddInlet(func(m glow.Message) {
-			g.Emit(1, m)
-			g.Emit(0, args)
-		})
-		g.AddInlet(func(m glow.Message) {
-			args = m
-		})
-		return g
-	}
+	glow.Register(glow.Spec{
+		Name:    "receive",
+		Aliases: []string{"r"},
+		Doc:     "Emits all messages sent to its name, which can have MQTT-style wildcards.",
+		Args: []glow.Param{
+			param("name", "string", "$0 is replaced by a unique id per design instance"),
+			param("scope", "string", "global (default), local, or up"),
+		},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			name, scope := scopedName(args)
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			var cancel func()
+			g.OnStart = func() {
+				if c := g.Parent(); c != nil {
+					cancel = c.Receive(name, scope, func(m glow.Message) {
+						g.Emit(0, m)
+					})
+				}
+			}
+			g.OnStop = func() {
+				if cancel != nil {
+					cancel()
+					cancel = nil
+				}
+			}
+			return g
+		},
+	})
 
-	glow.Registry["send"] = func(args glow.Message) glow.Gadgetry {
-		var parent *glow.Circuit
-		g := glow.NewGadget()
-		g.OnAdded = func(c *glow.Circuit) {
-			parent = c
-		}
-		g.AddInlet(func(m glow.Message) {
-			parent.Notify("msg:"+args.String(), m...)
-		})
-		return g
-	}
-	glow.Registry["s"] = glow.Registry["send"]
+	glow.Register(glow.Spec{
+		Name:    "metro",
+		Doc:     "Emits a bang periodically, while running.",
+		Args:    []glow.Param{param("period", "int", "in milliseconds")},
+		Outlets: []glow.Param{param("out", "bang", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			// TODO start on hot inlet, add 2nd inlet for changing period
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			var t *glow.Timer
+			g.OnStart = func() {
+				t = g.SetPeriodic(args.AsInt(), func() {
+					g.Emit(0, nil)
+				})
+			}
+			g.OnStop = func() {
+				g.CancelTimer(t)
+				t = nil
+			}
+			return g
+		},
+	})
 
-	glow.Registry["receive"] = func(args glow.Message) glow.Gadgetry {
-		g := glow.NewGadget()
-		g.AddOutlets(1)
-		g.OnAdded = func(c *glow.Circuit) {
-			c.On("msg:"+args.String(), func(m glow.Message) {
-				g.Emit(0, m)
+	glow.Register(glow.Spec{
+		Name: "smooth",
+		Doc:  "Emits the running average of incoming ints.",
+		Args: []glow.Param{param("order", "int", "")},
+		Inlets: []glow.Param{
+			param("in", "int", ""),
+			cold("order", "int", ""),
+		},
+		Outlets: []glow.Param{param("out", "int", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			state, order := 0, 0
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				state = (order*state + m.AsInt()) / (order + 1)
+				order = args.AsInt()
+				g.Emit(0, glow.Message{state})
 			})
-		}
-		return g
-	}
-	glow.Registry["r"] = glow.Registry["receive"]
-
-	glow.Registry["metro"] = func(args glow.Message) glow.Gadgetry {
-		// TODO start on hot inlet, add 2nd inlet for changing period
-		g := glow.NewGadget()
-		g.AddOutlets(1)
-		g.OnAdded = func(c *glow.Circuit) {
-			glow.SetPeriodic(args.AsInt(), func() {
-				g.Emit(0, nil)
+			g.AddColdInlet(func(m glow.Message) {
+				args = m
 			})
-		}
-		return g
-	}
+			return g
+		},
+	})
 
-	glow.Registry["smooth"] = func(args glow.Message) glow.Gadgetry {
-		state, order := 0, 0
-		g := glow.NewGadget()
-		g.AddOutlets(1)
-		g.AddInlet(func(m glow.Message) {
-			state = (order*state + m.AsInt()) / (order + 1)
-			order = args.AsInt()
-			g.Emit(0, glow.Message{state})
-		})
-		g.AddInlet(func(m glow.Message) {
-			args = m
-		})
-		return g
-	}
+	glow.Register(glow.Spec{
+		Name:    "change",
+		Doc:     "Passes on messages which differ from the previous one.",
+		Inlets:  []glow.Param{param("in", "any", "")},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			var last glow.Message
+			seen := false
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				if !seen || !m.Equal(last) {
+					last, seen = m, true
+					g.Emit(0, last)
+				}
+			})
+			return g
+		},
+	})
 
-	glow.Registry["change"] = func(args glow.Message) glow.Gadgetry {
-		var last glow.Message
-		g := glow.NewGadget()
-		g.AddOutlets(1)
-		g.AddInlet(func(m glow.Message) {
-			if last == nil || m.AsInt() != last.AsInt() {
-				last = m
-				g.Emit(0, last)
+	glow.Register(glow.Spec{
+		Name: "moses",
+		Doc:  "Sends messages which sort below the threshold out on the left, the rest on the right.",
+		Args: []glow.Param{param("threshold", "any", "")},
+		Inlets: []glow.Param{
+			param("in", "any", ""),
+			cold("threshold", "any", ""),
+		},
+		Outlets: []glow.Param{
+			param("below", "any", ""),
+			param("above", "any", ""),
+		},
+		New: func(args glow.Message) glow.Gadgetry {
+			if len(args) == 0 {
+				args = glow.Message{0}
 			}
-		})
-		return g
-	}
+			g := glow.NewGadget()
+			g.AddOutlets(2)
+			g.AddInlet(func(m glow.Message) {
+				if m.Compare(args) < 0 {
+					g.Emit(0, m)
+				} else {
+					g.Emit(1, m)
+				}
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				args = m
+			})
+			return g
+		},
+	})
+}
 
-	glow.Registry["moses"] = func(args glow.Message) glow.Gadgetry {
-		g := glow.NewGadget()
-		g.AddOutlets(2)
-		g.AddInlet(func(m glow.Message) {
-			if m.AsInt() < args.AsInt() {
-				g.Emit(0, m)
-			} else {
-				g.Emit(1, m)
-			}
-		})
-		g.AddInlet(func(m glow.Message) {
-			args = m
-		})
-		return g
+// scopedName splits the args of send and receive into a name and an optional
+// scope, i.e. "global", "local", or "up".
+func scopedName(args glow.Message) (string, glow.Scope) {
+	if n := len(args) - 1; n > 0 {
+		if scope, err := glow.ParseScope(args.At(n).AsString()); err == nil {
+			return args[:n].String(), scope
+		}
 	}
+	return args.String(), glow.Global
+}
+
+// param describes an argument, inlet, or outlet of a gadget.
+func param(name, typ, doc string) glow.Param {
+	return glow.Param{Name: name, Type: typ, Doc: doc}
+}
+
+// cold describes a cold inlet of a gadget.
+func cold(name, typ, doc string) glow.Param {
+	return glow.Param{Name: name, Type: typ, Doc: doc, Cold: true}
 }
@@ -0,0 +1,775 @@
+package gadgets
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+// These gadgets mirror the control objects of Pd vanilla, see Pd's help
+// patches for details. Since Pd only has floats, numeric results are ints
+// when all the inputs are ints and the result has no fraction.
+
+func init() {
+	glow.Register(glow.Spec{
+		Name:    "bang",
+		Aliases: []string{"b"},
+		Doc:     "Turns any message into a bang.",
+		Inlets:  []glow.Param{param("in", "any", "")},
+		Outlets: []glow.Param{param("out", "bang", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				g.Emit(0, glow.Message{})
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name:    "trigger",
+		Aliases: []string{"t"},
+		Doc:     "Sends its input out from right to left, converted for each outlet.",
+		Args: []glow.Param{
+			param("kinds", "list", "b, f, s, l, a, or a constant, for each outlet (default: f f)"),
+		},
+		Inlets:   []glow.Param{param("in", "any", "")},
+		Outlets:  []glow.Param{param("out", "any", "")},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			if len(args) == 0 {
+				args = glow.Message{"f", "f"}
+			}
+			g := glow.NewGadget()
+			g.AddOutlets(len(args))
+			g.AddInlet(func(m glow.Message) {
+				for o := len(args) - 1; o >= 0; o-- {
+					g.Emit(o, triggered(args.At(o), m))
+				}
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name:    "float",
+		Aliases: []string{"f"},
+		Doc:     "Stores a number, and emits it.",
+		Args:    []glow.Param{param("value", "number", "")},
+		Inlets: []glow.Param{
+			param("in", "number", "sets and emits the value, a bang just emits it"),
+			cold("value", "number", ""),
+		},
+		Outlets: []glow.Param{param("out", "number", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			return newStore(args, false)
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name:    "int",
+		Aliases: []string{"i"},
+		Doc:     "Stores an int, and emits it. Floats are truncated.",
+		Args:    []glow.Param{param("value", "int", "")},
+		Inlets: []glow.Param{
+			param("in", "number", "sets and emits the value, a bang just emits it"),
+			cold("value", "number", ""),
+		},
+		Outlets: []glow.Param{param("out", "int", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			return newStore(args, true)
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name:    "select",
+		Aliases: []string{"sel"},
+		Doc:     "Emits a bang on the outlet of the first arg equal to the input, or else passes the input on to the rightmost outlet.",
+		Args:    []glow.Param{param("values", "list", "")},
+		Inlets: []glow.Param{
+			param("in", "any", ""),
+			cold("value", "any", "only if there is a single value"),
+		},
+		Outlets: []glow.Param{
+			param("match", "bang", "one per value"),
+			param("reject", "any", ""),
+		},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			if len(args) == 0 {
+				args = glow.Message{0}
+			}
+			g := glow.NewGadget()
+			g.AddOutlets(len(args) + 1)
+			g.AddInlet(func(m glow.Message) {
+				if len(m) == 1 {
+					for k, a := range args {
+						if m.Equal(glow.Message{a}) {
+							g.Emit(k, glow.Message{})
+							return
+						}
+					}
+				}
+				g.Emit(len(args), m)
+			})
+			if len(args) == 1 {
+				g.AddColdInlet(func(m glow.Message) {
+					if len(m) > 0 {
+						args = glow.Message{m[0]}
+					}
+				})
+			}
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "route",
+		Doc:  "Emits the rest of a message on the outlet of the first arg equal to its first element, or matching its type (bang, float, symbol, list), or else the whole message on the rightmost outlet.",
+		Args: []glow.Param{param("selectors", "list", "")},
+		Inlets: []glow.Param{
+			param("in", "any", ""),
+			cold("selector", "any", "only if there is a single selector"),
+		},
+		Outlets: []glow.Param{
+			param("match", "any", "one per selector"),
+			param("reject", "any", ""),
+		},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			if len(args) == 0 {
+				args = glow.Message{0}
+			}
+			g := glow.NewGadget()
+			g.AddOutlets(len(args) + 1)
+			g.AddInlet(func(m glow.Message) {
+				for k, a := range args {
+					switch {
+					case a == "bang" && m.IsBang(),
+						a == "float" && m.IsNumber(),
+						a == "symbol" && m.IsString(),
+						a == "list" && len(m) > 1:
+						g.Emit(k, m)
+						return
+					case len(m) > 0 && m[:1].Equal(glow.Message{a}):
+						g.Emit(k, m[1:])
+						return
+					}
+				}
+				g.Emit(len(args), m)
+			})
+			if len(args) == 1 {
+				g.AddColdInlet(func(m glow.Message) {
+					if len(m) > 0 {
+						args = glow.Message{m[0]}
+					}
+				})
+			}
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "pack",
+		Doc:  "Combines values into a list, which is emitted when the left inlet gets a message.",
+		Args: []glow.Param{
+			param("slots", "list", "f, s, or an initial value, for each slot (default: f f)"),
+		},
+		Inlets: []glow.Param{
+			param("in", "any", "sets the first slot, or several from a list"),
+			cold("slot", "any", "one per additional slot"),
+		},
+		Outlets:  []glow.Param{param("out", "list", "")},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			if len(args) == 0 {
+				args = glow.Message{"f", "f"}
+			}
+			slots := make(glow.Message, len(args))
+			for i, a := range args {
+				switch a {
+				case "f", "float", "a", "anything":
+					slots[i] = 0
+				case "s", "symbol":
+					slots[i] = ""
+				default:
+					slots[i] = a
+				}
+			}
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				copy(slots, m)
+				g.Emit(0, append(glow.Message(nil), slots...))
+			})
+			for i := 1; i < len(slots); i++ {
+				i := i
+				g.AddColdInlet(func(m glow.Message) {
+					if len(m) > 0 {
+						slots[i] = m[0]
+					}
+				})
+			}
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "unpack",
+		Doc:  "Splits a list, and emits its elements from right to left.",
+		Args: []glow.Param{
+			param("slots", "list", "one per outlet (default: 2)"),
+		},
+		Inlets:   []glow.Param{param("in", "list", "")},
+		Outlets:  []glow.Param{param("out", "any", "one per slot")},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			n := len(args)
+			if n == 0 {
+				n = 2
+			}
+			g := glow.NewGadget()
+			g.AddOutlets(n)
+			g.AddInlet(func(m glow.Message) {
+				k := n
+				if len(m) < k {
+					k = len(m)
+				}
+				for o := k - 1; o >= 0; o-- {
+					g.Emit(o, m.At(o))
+				}
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "spigot",
+		Doc:  "Passes messages on while it is open.",
+		Args: []glow.Param{param("open", "bool", "")},
+		Inlets: []glow.Param{
+			param("in", "any", ""),
+			cold("open", "bool", ""),
+		},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			open := args.At(0).AsBool()
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				if open {
+					g.Emit(0, m)
+				}
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				open = m.At(0).AsBool()
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "gate",
+		Doc:  "Passes messages on to the selected outlet, or nowhere if it is 0.",
+		Args: []glow.Param{
+			param("outlets", "int", ""),
+			param("state", "int", "the initial selection"),
+		},
+		Inlets: []glow.Param{
+			cold("select", "int", ""),
+			param("in", "any", ""),
+		},
+		Outlets:  []glow.Param{param("out", "any", "one per outlet")},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			n, state := args.At(0).AsInt(), args.At(1).AsInt()
+			if n < 1 {
+				n = 1
+			}
+			g := glow.NewGadget()
+			g.AddOutlets(n)
+			g.AddColdInlet(func(m glow.Message) {
+				state = m.At(0).AsInt()
+			})
+			g.AddInlet(func(m glow.Message) {
+				if state > 0 && state <= n {
+					g.Emit(state-1, m)
+				}
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "counter",
+		Doc:  "Counts bangs, from min to max, and then wraps around.",
+		Args: []glow.Param{
+			param("min", "int", "0 if only max is given"),
+			param("max", "int", "no limit if omitted"),
+		},
+		Inlets: []glow.Param{
+			param("in", "any", "a bang emits the count, a number sets and emits it"),
+			cold("next", "int", "sets the next count, a bang resets it"),
+		},
+		Outlets: []glow.Param{
+			param("count", "int", ""),
+			param("carry", "bang", "when max is reached"),
+		},
+		New: func(args glow.Message) glow.Gadgetry {
+			min, max := 0, -1 // no upper limit
+			switch len(args) {
+			case 1:
+				max = args.At(0).AsInt()
+			case 2:
+				min, max = args.At(0).AsInt(), args.At(1).AsInt()
+			}
+			count := min
+			g := glow.NewGadget()
+			g.AddOutlets(2)
+			emit := func() {
+				if count == max {
+					g.Emit(1, glow.Message{})
+				}
+				g.Emit(0, glow.Message{count})
+			}
+			g.AddInlet(func(m glow.Message) {
+				if !m.IsBang() {
+					count = m.At(0).AsInt()
+				}
+				emit()
+				if count++; max >= min && count > max {
+					count = min
+				}
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				count = min
+				if !m.IsBang() {
+					count = m.At(0).AsInt()
+				}
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name:    "delay",
+		Aliases: []string{"del"},
+		Doc:     "Emits a bang after a delay.",
+		Args:    []glow.Param{param("delay", "int", "in milliseconds")},
+		Inlets: []glow.Param{
+			param("in", "any", "a bang (re)starts, a number sets the delay and starts, stop cancels"),
+			cold("delay", "int", ""),
+		},
+		Outlets: []glow.Param{param("out", "bang", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			ms := args.At(0).AsInt()
+			var t *glow.Timer
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			cancel := func() {
+				g.CancelTimer(t)
+				t = nil
+			}
+			g.AddInlet(func(m glow.Message) {
+				if m.At(0).AsString() == "stop" {
+					cancel()
+					return
+				}
+				if m.IsNumber() {
+					ms = m.AsInt()
+				}
+				cancel()
+				t = g.SetTimer(ms, func() {
+					t = nil
+					g.Emit(0, glow.Message{})
+				})
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				ms = m.At(0).AsInt()
+			})
+			g.OnStop = cancel
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "pipe",
+		Doc:  "Delays each message separately.",
+		Args: []glow.Param{param("delay", "int", "in milliseconds")},
+		Inlets: []glow.Param{
+			param("in", "any", "a bang repeats the last message, flush sends all now, clear drops all"),
+			cold("delay", "int", ""),
+		},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			ms, last := 0, glow.Message{0}
+			if len(args) > 0 {
+				ms = args.At(len(args) - 1).AsInt()
+			}
+			type item struct {
+				t *glow.Timer
+				m glow.Message
+			}
+			var pending []*item
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			drop := func(it *item) {
+				for i, x := range pending {
+					if x == it {
+						pending = append(pending[:i:i], pending[i+1:]...)
+						break
+					}
+				}
+			}
+			clear := func() {
+				for _, it := range pending {
+					g.CancelTimer(it.t)
+				}
+				pending = nil
+			}
+			g.AddInlet(func(m glow.Message) {
+				switch m.At(0).AsString() {
+				case "flush":
+					items := pending
+					clear()
+					for _, it := range items {
+						g.Emit(0, it.m)
+					}
+					return
+				case "clear":
+					clear()
+					return
+				}
+				if !m.IsBang() {
+					last = m
+				}
+				it := &item{m: last}
+				it.t = g.SetTimer(ms, func() {
+					drop(it)
+					g.Emit(0, it.m)
+				})
+				pending = append(pending, it)
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				ms = m.At(0).AsInt()
+			})
+			g.OnStop = clear
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "line",
+		Doc:  "Ramps from the current value to a target value over a period of time.",
+		Args: []glow.Param{
+			param("value", "float", "the initial value"),
+			param("grain", "int", "ms between outputs (default: 20)"),
+		},
+		Inlets: []glow.Param{
+			param("in", "list", "a target and optional time, or stop"),
+			cold("time", "int", "for the next ramp"),
+			cold("grain", "int", ""),
+		},
+		Outlets: []glow.Param{param("out", "float", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			value, grain, ramp := args.At(0).AsFloat(), args.At(1).AsInt(), 0
+			if grain <= 0 {
+				grain = 20
+			}
+			var t *glow.Timer
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			cancel := func() {
+				g.CancelTimer(t)
+				t = nil
+			}
+			g.AddInlet(func(m glow.Message) {
+				if m.At(0).AsString() == "stop" {
+					cancel()
+					return
+				}
+				target, ms := m.At(0).AsFloat(), ramp
+				if len(m) > 1 {
+					ms = m.At(1).AsInt()
+				}
+				ramp = 0 // only used once, as in Pd
+				cancel()
+				if ms <= 0 {
+					value = target
+					g.Emit(0, glow.Message{value})
+					return
+				}
+				from, start := value, g.Now()
+				tick := func() {
+					elapsed := g.Now() - start
+					if elapsed >= ms {
+						cancel()
+						value = target
+					} else {
+						value = from + (target-from)*float64(elapsed)/float64(ms)
+					}
+					g.Emit(0, glow.Message{value})
+				}
+				t = g.SetPeriodic(grain, tick)
+				tick()
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				ramp = m.At(0).AsInt()
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				if grain = m.At(0).AsInt(); grain <= 0 {
+					grain = 20
+				}
+			})
+			g.OnStop = cancel
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "random",
+		Doc:  "Emits a pseudo-random int from zero up to (but not including) the range.",
+		Args: []glow.Param{param("range", "int", "")},
+		Inlets: []glow.Param{
+			param("in", "any", "a bang, or seed and a number"),
+			cold("range", "int", ""),
+		},
+		Outlets: []glow.Param{param("out", "int", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			n := args.At(0).AsInt()
+			r := rand.New(rand.NewSource(atomic.AddInt64(&randomSeed, 1)))
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				if m.At(0).AsString() == "seed" {
+					r.Seed(int64(m.At(1).AsInt()))
+					return
+				}
+				v := 0
+				if n > 1 {
+					v = r.Intn(n)
+				}
+				g.Emit(0, glow.Message{v})
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				n = m.At(0).AsInt()
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "until",
+		Doc:  "Emits bangs in a loop, a number of times, or until it is stopped.",
+		Inlets: []glow.Param{
+			param("in", "any", "a number of bangs, or a bang to loop until stopped"),
+			cold("stop", "bang", ""),
+		},
+		Outlets: []glow.Param{param("out", "bang", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			stop := false
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				n := -1 // a bang loops until stopped
+				if m.IsNumber() {
+					n = m.AsInt()
+				}
+				stop = false
+				for i := 0; (n < 0 || i < n) && !stop; i++ {
+					g.Emit(0, glow.Message{})
+				}
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				stop = true
+			})
+			return g
+		},
+	})
+
+	for name, op := range arithmetic {
+		registerOperator(name, "Emits "+opDoc(name)+".", newOperator(op, false))
+	}
+	for name, op := range comparison {
+		op := op
+		registerOperator(name, "Emits 1 if "+opDoc(name)+", else 0.",
+			newOperator(func(a, b float64) float64 {
+				if op(a, b) {
+					return 1
+				}
+				return 0
+			}, true))
+	}
+}
+
+// registerOperator registers a binary operator, as in Pd.
+func registerOperator(name, doc string, f func(args glow.Message) glow.Gadgetry) {
+	glow.Register(glow.Spec{
+		Name:    name,
+		Doc:     doc,
+		Args:    []glow.Param{param("right", "number", "")},
+		Inlets:  []glow.Param{param("left", "number", "a list sets both"), cold("right", "number", "")},
+		Outlets: []glow.Param{param("out", "number", "")},
+		New:     f,
+	})
+}
+
+// opDoc describes how an operator combines its left and right operands.
+func opDoc(name string) string {
+	if name[0] >= 'a' && name[0] <= 'z' {
+		return name + "(left, right)"
+	}
+	return "left " + name + " right"
+}
+
+// randomSeed is used to give each random gadget a different sequence.
+var randomSeed int64
+
+// arithmetic lists the binary operators which produce a number.
+var arithmetic = map[string]func(a, b float64) float64{
+	"+": func(a, b float64) float64 { return a + b },
+	"-": func(a, b float64) float64 { return a - b },
+	"*": func(a, b float64) float64 { return a * b },
+	"/": func(a, b float64) float64 {
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	},
+	"max": math.Max,
+	"min": math.Min,
+	"pow": func(a, b float64) float64 {
+		if v := math.Pow(a, b); !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return v
+		}
+		return 0
+	},
+	"mod": func(a, b float64) float64 {
+		n := divisor(b)
+		v := int(a) % n
+		if v < 0 {
+			v += n
+		}
+		return float64(v)
+	},
+	"div": func(a, b float64) float64 {
+		n, v := divisor(b), int(a)
+		if v < 0 {
+			v -= n - 1
+		}
+		return float64(v / n)
+	},
+	"%": func(a, b float64) float64 {
+		return float64(int(a) % divisor(b))
+	},
+}
+
+// comparison lists the binary operators which produce 0 or 1.
+var comparison = map[string]func(a, b float64) bool{
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+	">":  func(a, b float64) bool { return a > b },
+	"<":  func(a, b float64) bool { return a < b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"&&": func(a, b float64) bool { return a != 0 && b != 0 },
+	"||": func(a, b float64) bool { return a != 0 || b != 0 },
+}
+
+// divisor returns the absolute integer value of b, or 1 if it is zero.
+func divisor(b float64) int {
+	n := int(b)
+	if n < 0 {
+		n = -n
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// newOperator returns a constructor for a binary operator gadget. The left
+// inlet is hot, the right one is cold, and a list sets both operands.
+func newOperator(op func(a, b float64) float64, ints bool) func(glow.Message) glow.Gadgetry {
+	return func(args glow.Message) glow.Gadgetry {
+		left, right := glow.Message{0}, glow.Message{0}
+		if args.At(0).IsNumber() {
+			right = args.At(0)
+		}
+		g := glow.NewGadget()
+		g.AddOutlets(1)
+		g.AddInlet(func(m glow.Message) {
+			if len(m) > 1 {
+				right = m.At(1)
+			}
+			if !m.IsBang() {
+				left = m.At(0)
+			}
+			v := op(left.AsFloat(), right.AsFloat())
+			g.Emit(0, glow.Message{number(v, ints || left.IsInt() && right.IsInt())})
+		})
+		g.AddColdInlet(func(m glow.Message) {
+			right = m.At(0)
+		})
+		return g
+	}
+}
+
+// newStore returns a gadget which stores a number, as the float and int
+// gadgets do. The int version truncates the number.
+func newStore(args glow.Message, ints bool) glow.Gadgetry {
+	convert := func(m glow.Message) glow.Message {
+		if ints || !m.IsNumber() {
+			return glow.Message{m.AsInt()}
+		}
+		return m
+	}
+	value := convert(args.At(0))
+	g := glow.NewGadget()
+	g.AddOutlets(1)
+	g.AddInlet(func(m glow.Message) {
+		if !m.IsBang() {
+			value = convert(m.At(0))
+		}
+		g.Emit(0, value)
+	})
+	g.AddColdInlet(func(m glow.Message) {
+		value = convert(m.At(0))
+	})
+	return g
+}
+
+// triggered converts a message for one outlet of the trigger gadget: "b" is
+// a bang, "f" a number, "s" a string, "l" and "a" pass the message as is,
+// anything else is sent out as constant.
+func triggered(kind, m glow.Message) glow.Message {
+	switch kind.AsString() {
+	case "b", "bang":
+		return glow.Message{}
+	case "f", "float":
+		if x := m.At(0); x.IsNumber() {
+			return x
+		}
+		return glow.Message{0}
+	case "s", "symbol":
+		if x := m.At(0); x.IsString() {
+			return x
+		}
+		return glow.Message{""}
+	case "l", "list", "a", "anything":
+		return m
+	}
+	return kind
+}
+
+// number returns v as an int if ints is set and v has no fraction, else as
+// a float.
+func number(v float64, ints bool) interface{} {
+	if ints && v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		return int(v)
+	}
+	return v
+}
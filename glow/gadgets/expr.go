@@ -0,0 +1,466 @@
+package gadgets
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+func init() {
+	glow.Register(glow.Spec{
+		Name: "expr",
+		Doc:  "Evaluates expressions over its inputs $f1, $i2, $s3, etc. Each input adds an inlet, and expressions separated by \";\" each add an outlet. Integer arithmetic is used when all operands are ints, as in Pd's expr.",
+		Args: []glow.Param{param("expression", "list", "")},
+		Inlets: []glow.Param{
+			param("in", "any", "the first input, or a list of inputs"),
+			cold("input", "any", "one per additional input"),
+		},
+		Outlets:  []glow.Param{param("out", "any", "one per expression")},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			exprs, kinds, err := compileExpr(exprText(args))
+			if err != nil {
+				g.Fail(err)
+				return g
+			}
+			in := make([]exprValue, len(kinds))
+			for i, k := range kinds {
+				in[i] = exprInput(k, nil)
+			}
+			g.AddOutlets(len(exprs))
+			g.AddInlet(func(m glow.Message) {
+				for i := 0; i < len(m) && i < len(in); i++ {
+					in[i] = exprInput(kinds[i], m.At(i))
+				}
+				for o := len(exprs) - 1; o >= 0; o-- {
+					g.Emit(o, glow.Message{exprs[o](in).atom()})
+				}
+			})
+			for i := 1; i < len(in); i++ {
+				i := i
+				g.AddColdInlet(func(m glow.Message) {
+					in[i] = exprInput(kinds[i], m)
+				})
+			}
+			return g
+		},
+	})
+}
+
+// exprText turns gadget args back into the text of an expression.
+func exprText(args glow.Message) string {
+	v := make([]string, len(args))
+	for i, a := range args {
+		if s, ok := a.(string); ok {
+			v[i] = s
+		} else {
+			v[i] = glow.Message{a}.String()
+		}
+	}
+	return strings.Join(v, " ")
+}
+
+// An exprValue is the result of evaluating an expression: a number or string.
+type exprValue struct {
+	f     float64
+	s     string
+	isInt bool
+	isStr bool
+}
+
+// atom returns the value as message element.
+func (v exprValue) atom() interface{} {
+	switch {
+	case v.isStr:
+		return v.s
+	case v.isInt:
+		return int(v.f)
+	}
+	return v.f
+}
+
+// exprInput converts an incoming message to an input value of a given kind.
+func exprInput(kind byte, m glow.Message) exprValue {
+	switch kind {
+	case 'i':
+		return exprValue{f: float64(m.AsInt()), isInt: true}
+	case 's':
+		s := m.AsString()
+		if !m.IsString() && !m.IsBang() {
+			s = m.String()
+		}
+		return exprValue{s: s, isStr: true}
+	}
+	return exprValue{f: m.AsFloat()}
+}
+
+// exprNum returns an int or float value, depending on the flag.
+func exprNum(f float64, isInt bool) exprValue {
+	if isInt {
+		f = math.Trunc(f)
+	}
+	return exprValue{f: f, isInt: isInt}
+}
+
+// exprBool returns 1 or 0 as int value.
+func exprBool(b bool) exprValue {
+	if b {
+		return exprValue{f: 1, isInt: true}
+	}
+	return exprValue{isInt: true}
+}
+
+// An exprFunc evaluates a compiled expression for the given inputs.
+type exprFunc func(in []exprValue) exprValue
+
+// exprBuiltins are the functions which can be called in expressions.
+var exprBuiltins = map[string]struct {
+	n int // the number of arguments
+	f func(a []exprValue) exprValue
+}{
+	"abs":   {1, func(a []exprValue) exprValue { return exprNum(math.Abs(a[0].f), a[0].isInt) }},
+	"int":   {1, func(a []exprValue) exprValue { return exprNum(a[0].f, true) }},
+	"float": {1, func(a []exprValue) exprValue { return exprValue{f: a[0].f} }},
+	"floor": {1, func(a []exprValue) exprValue { return exprValue{f: math.Floor(a[0].f)} }},
+	"ceil":  {1, func(a []exprValue) exprValue { return exprValue{f: math.Ceil(a[0].f)} }},
+	"round": {1, func(a []exprValue) exprValue { return exprValue{f: math.Round(a[0].f)} }},
+	"sqrt":  {1, func(a []exprValue) exprValue { return exprValue{f: math.Sqrt(a[0].f)} }},
+	"exp":   {1, func(a []exprValue) exprValue { return exprValue{f: math.Exp(a[0].f)} }},
+	"ln":    {1, func(a []exprValue) exprValue { return exprValue{f: math.Log(a[0].f)} }},
+	"log":   {1, func(a []exprValue) exprValue { return exprValue{f: math.Log(a[0].f)} }},
+	"log10": {1, func(a []exprValue) exprValue { return exprValue{f: math.Log10(a[0].f)} }},
+	"sin":   {1, func(a []exprValue) exprValue { return exprValue{f: math.Sin(a[0].f)} }},
+	"cos":   {1, func(a []exprValue) exprValue { return exprValue{f: math.Cos(a[0].f)} }},
+	"tan":   {1, func(a []exprValue) exprValue { return exprValue{f: math.Tan(a[0].f)} }},
+	"asin":  {1, func(a []exprValue) exprValue { return exprValue{f: math.Asin(a[0].f)} }},
+	"acos":  {1, func(a []exprValue) exprValue { return exprValue{f: math.Acos(a[0].f)} }},
+	"atan":  {1, func(a []exprValue) exprValue { return exprValue{f: math.Atan(a[0].f)} }},
+	"atan2": {2, func(a []exprValue) exprValue { return exprValue{f: math.Atan2(a[0].f, a[1].f)} }},
+	"pow":   {2, func(a []exprValue) exprValue { return exprNum(math.Pow(a[0].f, a[1].f), a[0].isInt && a[1].isInt) }},
+	"fmod":  {2, func(a []exprValue) exprValue { return exprValue{f: math.Mod(a[0].f, a[1].f)} }},
+	"min": {2, func(a []exprValue) exprValue {
+		return exprNum(math.Min(a[0].f, a[1].f), a[0].isInt && a[1].isInt)
+	}},
+	"max": {2, func(a []exprValue) exprValue {
+		return exprNum(math.Max(a[0].f, a[1].f), a[0].isInt && a[1].isInt)
+	}},
+	"strlen": {1, func(a []exprValue) exprValue { return exprNum(float64(len(a[0].s)), true) }},
+}
+
+// exprBinary are the binary operators, from lowest to highest precedence.
+var exprBinary = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// exprOperate applies a binary operator to two values.
+func exprOperate(op string, a, b exprValue) exprValue {
+	ints := a.isInt && b.isInt
+	switch op {
+	case "==", "!=":
+		eq := a.f == b.f
+		if a.isStr || b.isStr {
+			eq = a.isStr == b.isStr && a.s == b.s
+		}
+		return exprBool(eq == (op == "=="))
+	case "<":
+		return exprBool(a.f < b.f)
+	case ">":
+		return exprBool(a.f > b.f)
+	case "<=":
+		return exprBool(a.f <= b.f)
+	case ">=":
+		return exprBool(a.f >= b.f)
+	case "&&":
+		return exprBool(a.f != 0 && b.f != 0)
+	case "||":
+		return exprBool(a.f != 0 || b.f != 0)
+	case "+":
+		return exprNum(a.f+b.f, ints)
+	case "-":
+		return exprNum(a.f-b.f, ints)
+	case "*":
+		return exprNum(a.f*b.f, ints)
+	case "/":
+		if b.f == 0 {
+			return exprNum(0, ints)
+		}
+		return exprNum(a.f/b.f, ints)
+	}
+	// the rest are integer operators
+	x, y := int(a.f), int(b.f)
+	switch op {
+	case "%":
+		if y == 0 {
+			return exprNum(0, true)
+		}
+		x %= y
+	case "&":
+		x &= y
+	case "|":
+		x |= y
+	case "^":
+		x ^= y
+	case "<<":
+		x <<= uint(y)
+	case ">>":
+		x >>= uint(y)
+	}
+	return exprNum(float64(x), true)
+}
+
+// compileExpr parses one or more expressions, separated by ";". It returns
+// an evaluator for each of them, and the kind of each input ('f', 'i', 's').
+func compileExpr(text string) ([]exprFunc, []byte, error) {
+	toks, err := exprTokens(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &exprParser{toks: toks}
+	var exprs []exprFunc
+	for {
+		f, err := p.parse(0)
+		if err != nil {
+			return nil, nil, err
+		}
+		exprs = append(exprs, f)
+		if p.peek() != ";" {
+			break
+		}
+		p.pos++
+	}
+	if p.pos < len(p.toks) {
+		return nil, nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	if len(p.kinds) == 0 {
+		p.kinds = []byte{'f'} // there's always at least one inlet
+	}
+	for i, k := range p.kinds {
+		if k == 0 {
+			p.kinds[i] = 'f' // unused, but still gets an inlet
+		}
+	}
+	return exprs, p.kinds, nil
+}
+
+// exprTokens splits the text of an expression into tokens.
+func exprTokens(s string) (toks []string, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		j := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+		case c >= '0' && c <= '9' || c == '.':
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' ||
+				s[j] == 'e' || (s[j] == '-' || s[j] == '+') && s[j-1] == 'e') {
+				j++
+			}
+		case c == '$' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' ||
+				s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+		case j < len(s) && exprPairs[s[i:j+1]]:
+			j++
+		case !strings.Contains("+-*/%<>!~&|^(),;", string(c)):
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+		toks = append(toks, s[i:j])
+		i = j
+	}
+	return
+}
+
+// exprPairs are the operators which consist of two characters.
+var exprPairs = map[string]bool{
+	"==": true, "!=": true, "<=": true, ">=": true,
+	"&&": true, "||": true, "<<": true, ">>": true,
+}
+
+// An exprParser is a recursive descent parser for expressions.
+type exprParser struct {
+	toks  []string
+	pos   int
+	kinds []byte // the kind of each input, as it is used
+}
+
+// peek returns the next token, or "" at the end.
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+// expect skips over a token, which must be the specified one.
+func (p *exprParser) expect(tok string) error {
+	if p.peek() != tok {
+		return fmt.Errorf("expected %q", tok)
+	}
+	p.pos++
+	return nil
+}
+
+// parse handles binary operators with the given precedence level or higher.
+func (p *exprParser) parse(level int) (exprFunc, error) {
+	if level >= len(exprBinary) {
+		return p.unary()
+	}
+	left, err := p.parse(level + 1)
+	for err == nil {
+		op := p.peek()
+		found := false
+		for _, x := range exprBinary[level] {
+			found = found || op == x
+		}
+		if !found {
+			break
+		}
+		p.pos++
+		var right exprFunc
+		right, err = p.parse(level + 1)
+		l := left
+		left = func(in []exprValue) exprValue {
+			return exprOperate(op, l(in), right(in))
+		}
+	}
+	return left, err
+}
+
+// unary handles prefix operators.
+func (p *exprParser) unary() (exprFunc, error) {
+	op := p.peek()
+	if op != "-" && op != "!" && op != "~" && op != "+" {
+		return p.primary()
+	}
+	p.pos++
+	f, err := p.unary()
+	return func(in []exprValue) exprValue {
+		v := f(in)
+		switch op {
+		case "-":
+			return exprNum(-v.f, v.isInt)
+		case "!":
+			return exprBool(v.f == 0)
+		case "~":
+			return exprNum(float64(^int(v.f)), true)
+		}
+		return v
+	}, err
+}
+
+// primary handles numbers, inputs, function calls, and parentheses.
+func (p *exprParser) primary() (exprFunc, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "":
+		return nil, errors.New("unexpected end of expression")
+	case tok == "(":
+		f, err := p.parse(0)
+		if err == nil {
+			err = p.expect(")")
+		}
+		return f, err
+	case tok[0] >= '0' && tok[0] <= '9' || tok[0] == '.':
+		if n, err := strconv.Atoi(tok); err == nil {
+			v := exprNum(float64(n), true)
+			return func([]exprValue) exprValue { return v }, nil
+		}
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", tok)
+		}
+		v := exprValue{f: f}
+		return func([]exprValue) exprValue { return v }, nil
+	case tok[0] == '$':
+		return p.input(tok)
+	case tok == "if":
+		a, err := p.args(3)
+		if err != nil {
+			return nil, err
+		}
+		return func(in []exprValue) exprValue {
+			if a[0](in).f != 0 {
+				return a[1](in)
+			}
+			return a[2](in)
+		}, nil
+	}
+	fn, ok := exprBuiltins[tok]
+	if !ok {
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+	a, err := p.args(fn.n)
+	if err != nil {
+		return nil, err
+	}
+	return func(in []exprValue) exprValue {
+		v := make([]exprValue, len(a))
+		for i, f := range a {
+			v[i] = f(in)
+		}
+		return fn.f(v)
+	}, nil
+}
+
+// input handles references to inputs, such as "$f1".
+func (p *exprParser) input(tok string) (exprFunc, error) {
+	if len(tok) < 3 || !strings.Contains("fis", tok[1:2]) {
+		return nil, fmt.Errorf("bad input %q", tok)
+	}
+	n, err := strconv.Atoi(tok[2:])
+	if err != nil || n < 1 || n > 100 {
+		return nil, fmt.Errorf("bad input %q", tok)
+	}
+	for len(p.kinds) < n {
+		p.kinds = append(p.kinds, 0)
+	}
+	kind := tok[1]
+	if p.kinds[n-1] != 0 && p.kinds[n-1] != kind {
+		return nil, fmt.Errorf("input %d used as different types", n)
+	}
+	p.kinds[n-1] = kind
+	return func(in []exprValue) exprValue { return in[n-1] }, nil
+}
+
+// args parses the n comma-separated arguments of a function call.
+func (p *exprParser) args(n int) ([]exprFunc, error) {
+	name := p.toks[p.pos-1]
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var a []exprFunc
+	for p.peek() != ")" {
+		if len(a) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		f, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, f)
+	}
+	p.pos++
+	if len(a) != n {
+		return nil, fmt.Errorf("%s needs %d args, got %d", name, n, len(a))
+	}
+	return a, nil
+}
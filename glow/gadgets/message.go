@@ -0,0 +1,55 @@
+package gadgets
+
+import (
+	"github.com/jeelabs/jet/glow"
+)
+
+func init() {
+	glow.Register(glow.Spec{
+		Name:    "message",
+		Aliases: []string{"msg"},
+		Doc:     "Emits its content when it gets a message, with $1, $2, etc. replaced by its elements. A \",\" separates messages, and a \";\" followed by a name sends the remaining ones to that receiver. Just \"bang\" is a bang, and a leading \"float\", \"symbol\", or \"list\" is dropped, as in Pd.",
+		Args:    []glow.Param{param("content", "list", "")},
+		Inlets:  []glow.Param{param("in", "any", "set replaces the content")},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			content := args
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				if m.At(0).AsString() == "set" {
+					content = append(glow.Message(nil), m[1:]...)
+					return
+				}
+				var msg glow.Message
+				target, naming := "", false
+				flush := func() {
+					switch {
+					case target != "":
+						if c := g.Parent(); c != nil { // dropped outside a circuit
+							c.Send(target, glow.Global, msg.StripSelector())
+						}
+					case len(msg) > 0:
+						g.Emit(0, msg.StripSelector())
+					}
+					msg = nil
+				}
+				for _, a := range content.Substitute(m) {
+					switch {
+					case naming:
+						target, naming = glow.Message{a}.String(), false
+					case a == ",":
+						flush()
+					case a == ";":
+						flush()
+						target, naming = "", true
+					default:
+						msg = append(msg, a)
+					}
+				}
+				flush()
+			})
+			return g
+		},
+	})
+}
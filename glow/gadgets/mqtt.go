@@ -1,39 +1,389 @@
 package gadgets
 
 import (
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/jeelabs/jet/glow"
+	"github.com/jeelabs/jet/glow/codec"
 )
 
+const brokerDoc = "default: tcp://localhost:1883, add ?will=topic&payload=...&qos=1&retain=1 for a last will"
+
 func init() {
-	glow.Registry["mqtt"] = func(args glow.Message) glow.Gadgetry {
-		pattern := args.At(0).AsString()
-		if pattern == "" {
-			pattern = "#"
+	glow.Register(glow.Spec{
+		Name:    "mqtt-sub",
+		Aliases: []string{"mqtt"},
+		Doc:     "Subscribes to an MQTT broker, and emits the topic and payload of incoming messages.",
+		Args: []glow.Param{
+			param("pattern", "string", "topics to subscribe to (default: #)"),
+			param("broker", "string", brokerDoc),
+			param("format", "string", "a codec for the payload, e.g. json or cbor"),
+			param("qos", "int", "0, 1, or 2"),
+		},
+		Outlets: []glow.Param{
+			param("out", "list", "topic and payload"),
+			param("state", "list", "connected, or disconnected and the reason"),
+		},
+		New: func(args glow.Message) glow.Gadgetry {
+			pattern := args.At(0).AsString()
+			if pattern == "" {
+				pattern = "#"
+			}
+			format := codec.Registry[args.At(2).AsString()] // optional
+
+			g := glow.NewGadget()
+			g.AddOutlets(2)
+			u := &mqttUser{
+				pattern: pattern,
+				qos:     byte(args.At(3).AsInt()),
+				onMessage: func(m mqtt.Message) {
+					var payload interface{} = string(m.Payload())
+					if format != nil {
+						if v, err := format.Unmarshal(m.Payload()); err == nil {
+							payload = v
+						}
+					}
+					g.Emit(0, glow.Message{m.Topic(), payload})
+				},
+				onState: func(m glow.Message) {
+					g.Emit(1, m)
+				},
+			}
+			useMqtt(g, args.At(1).AsString(), u)
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "mqtt-pub",
+		Doc:  "Publishes incoming messages to an MQTT broker.",
+		Args: []glow.Param{
+			param("topic", "string", "if omitted, the first element of each message is the topic"),
+			param("broker", "string", brokerDoc),
+			param("format", "string", "a codec for the payload, e.g. json or cbor"),
+			param("qos", "int", "0, 1, or 2"),
+			param("retain", "bool", ""),
+		},
+		Inlets: []glow.Param{
+			param("in", "any", "the payload, or a topic and payload"),
+			cold("topic", "string", ""),
+		},
+		Outlets: []glow.Param{
+			param("state", "list", "connected, or disconnected and the reason"),
+		},
+		New: func(args glow.Message) glow.Gadgetry {
+			topic := args.At(0).AsString()
+			format := codec.Registry[args.At(2).AsString()] // optional
+			qos := byte(args.At(3).AsInt())
+			retain := args.At(4).AsBool()
+
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			u := &mqttUser{
+				onState: func(m glow.Message) {
+					g.Emit(0, m)
+				},
+			}
+			conn := useMqtt(g, args.At(1).AsString(), u)
+			g.AddInlet(func(m glow.Message) {
+				t := topic
+				if t == "" && len(m) > 0 {
+					t, m = m.At(0).AsString(), m[1:]
+				}
+				c := conn()
+				if t == "" || c == nil {
+					return
+				}
+				var payload []byte
+				if format != nil {
+					b, err := format.Marshal(m)
+					if err != nil {
+						return
+					}
+					payload = b
+				} else if m.IsString() {
+					payload = []byte(m.AsString())
+				} else {
+					payload = []byte(m.String())
+				}
+				c.client.Publish(t, qos, retain, payload) // not waiting for it
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				topic = m.AsString()
+			})
+			return g
+		},
+	})
+}
+
+// useMqtt arranges for a gadget to use a pooled broker connection while it is
+// running. It returns a function to get the current connection, if any.
+func useMqtt(g *glow.Gadget, broker string, u *mqttUser) func() *mqttConn {
+	if broker == "" {
+		broker = "tcp://localhost:1883"
+	}
+	opts, err := mqttOptions(broker)
+	if err != nil {
+		g.Fail(err)
+		return func() *mqttConn { return nil }
+	}
+
+	u.g = g
+	var c *mqttConn
+	g.OnStart = func() {
+		var state glow.Message
+		c, state = acquireMqtt(broker, opts, u)
+		c.post(u, func() { u.onState(state) })
+	}
+	g.OnStop = func() {
+		c.remove(u)
+		c = nil
+	}
+	return func() *mqttConn { return c }
+}
+
+// mqttOptions sets up the client options for a broker URL. The query string,
+// if any, specifies the last will and testament of the connection.
+func mqttOptions(broker string) (*mqtt.ClientOptions, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	u.RawQuery = ""
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(u.String())
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(mqttBackoff[1])
+	if t := q.Get("will"); t != "" {
+		qos, _ := strconv.Atoi(q.Get("qos"))
+		retain, _ := strconv.ParseBool(q.Get("retain"))
+		opts.SetWill(t, q.Get("payload"), byte(qos), retain)
+	}
+	return opts, nil
+}
+
+// mqttBackoff is the initial and the maximum delay between connect attempts.
+var mqttBackoff = [2]time.Duration{time.Second, time.Minute}
+
+// mqttPool has all the broker connections in use, by broker URL.
+var mqttPool = struct {
+	sync.Mutex
+	conns map[string]*mqttConn
+}{conns: map[string]*mqttConn{}}
+
+// An mqttUser is a gadget which is using a broker connection. The pattern is
+// empty if it doesn't subscribe to anything. The callbacks are called on the
+// event loop of the circuit the gadget was in when it started using the
+// connection, and only as long as it is still using it.
+type mqttUser struct {
+	g         *glow.Gadget
+	parent    *glow.Circuit // protected by the connection's mutex
+	pattern   string
+	qos       byte
+	onMessage func(m mqtt.Message)
+	onState   func(m glow.Message)
+}
+
+// An mqttConn is a connection to a broker, shared by all its users. It keeps
+// trying to connect, and reconnects when the connection is lost.
+type mqttConn struct {
+	broker string
+	client mqtt.Client
+	done   chan struct{} // closed when the last user is gone
+
+	mu    sync.Mutex
+	users map[*mqttUser]bool
+	state glow.Message
+}
+
+// acquireMqtt adds a user to the connection to a broker, after creating it if
+// needed, and returns it with its current state. The connection is closed
+// again once all its users have been removed.
+func acquireMqtt(broker string, opts *mqtt.ClientOptions, u *mqttUser) (*mqttConn, glow.Message) {
+	mqttPool.Lock()
+	defer mqttPool.Unlock()
+	if c := mqttPool.conns[broker]; c != nil {
+		return c, c.add(u)
+	}
+	c := &mqttConn{
+		broker: broker,
+		done:   make(chan struct{}),
+		users:  map[*mqttUser]bool{},
+		state:  glow.Message{"disconnected"},
+	}
+	opts.SetOnConnectHandler(func(mqtt.Client) { c.connected() })
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		c.setState(glow.Message{"disconnected", err.Error()})
+	})
+	c.client = mqtt.NewClient(opts)
+	mqttPool.conns[broker] = c
+	state := c.add(u)
+	go c.connect()
+	return c, state
+}
+
+// connect tries to connect to the broker, with exponential backoff between
+// failed attempts, until it succeeds or the connection is no longer needed.
+func (c *mqttConn) connect() {
+	delay := mqttBackoff[0]
+	for {
+		t := c.client.Connect()
+		t.Wait()
+		if t.Error() == nil {
+			select {
+			case <-c.done: // too late, no one needs it anymore
+				c.client.Disconnect(250)
+			default:
+			}
+			return
 		}
-		broker := args.At(1).AsString()
-		if broker == "" {
-			broker = "tcp://localhost:1883"
+		c.setState(glow.Message{"disconnected", t.Error().Error()})
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
 		}
+		if delay *= 2; delay > mqttBackoff[1] {
+			delay = mqttBackoff[1]
+		}
+	}
+}
 
-		g := glow.NewGadget()
-		g.AddOutlets(1)
-
-		opts := mqtt.NewClientOptions()
-		opts.AddBroker(broker)
+// connected (re-)subscribes to all patterns, since the session is not kept.
+func (c *mqttConn) connected() {
+	c.mu.Lock()
+	qos := map[string]byte{}
+	for u := range c.users {
+		if u.pattern != "" && u.qos >= qos[u.pattern] {
+			qos[u.pattern] = u.qos
+		}
+	}
+	c.mu.Unlock()
+	var tokens []mqtt.Token
+	for p, q := range qos {
+		tokens = append(tokens, c.client.Subscribe(p, q, c.dispatch(p)))
+	}
+	for _, t := range tokens {
+		t.Wait() // so that "connected" implies "subscribed"
+	}
+	c.setState(glow.Message{"connected"})
+}
 
-		c := mqtt.NewClient(opts)
-		if t := c.Connect(); t.Wait() && t.Error() != nil {
-			panic(t.Error())
+// dispatch returns a handler which passes messages to all users of a pattern.
+func (c *mqttConn) dispatch(pattern string) mqtt.MessageHandler {
+	return func(_ mqtt.Client, m mqtt.Message) {
+		c.mu.Lock()
+		var v []*mqttUser
+		for u := range c.users {
+			if u.pattern == pattern {
+				v = append(v, u)
+			}
 		}
+		c.mu.Unlock()
+		for _, u := range v {
+			u := u
+			c.post(u, func() { u.onMessage(m) })
+		}
+	}
+}
 
-		var f mqtt.MessageHandler = func(c mqtt.Client, m mqtt.Message) {
-			g.Emit(0, glow.Message{string(m.Topic()), string(m.Payload())})
+// setState reports a change in connection state to all users.
+func (c *mqttConn) setState(m glow.Message) {
+	c.mu.Lock()
+	c.state = m
+	var v []*mqttUser
+	for u := range c.users {
+		v = append(v, u)
+	}
+	c.mu.Unlock()
+	for _, u := range v {
+		u := u
+		c.post(u, func() { u.onState(m) })
+	}
+}
+
+// post runs a callback of a user on its event loop, unless the user has been
+// removed from the connection by then.
+func (c *mqttConn) post(u *mqttUser, f func()) {
+	c.mu.Lock()
+	p := u.parent
+	c.mu.Unlock()
+	run := func() {
+		c.mu.Lock()
+		ok := c.users[u]
+		c.mu.Unlock()
+		if ok {
+			f()
 		}
-		if t := c.Subscribe(pattern, 0, f); t.Wait() && t.Error() != nil {
-			panic(t.Error())
+	}
+	if p != nil {
+		p.Post(run)
+	} else {
+		run()
+	}
+}
+
+// qos returns the highest QoS of all users of a pattern, and whether there
+// are any. The mutex must be held.
+func (c *mqttConn) qos(pattern string) (qos byte, used bool) {
+	for u := range c.users {
+		if u.pattern == pattern {
+			if !used || u.qos > qos {
+				qos = u.qos
+			}
+			used = true
 		}
+	}
+	return
+}
+
+// add a user to the connection, and subscribe to its pattern if needed. An
+// existing subscription is only replaced when the highest QoS goes up.
+func (c *mqttConn) add(u *mqttUser) glow.Message {
+	c.mu.Lock()
+	old, used := c.qos(u.pattern)
+	u.parent = u.g.Parent()
+	c.users[u] = true
+	qos, _ := c.qos(u.pattern)
+	state := c.state
+	c.mu.Unlock()
+	if u.pattern != "" && (!used || qos > old) && c.client.IsConnected() {
+		c.client.Subscribe(u.pattern, qos, c.dispatch(u.pattern))
+	}
+	return state
+}
 
-		return g
+// remove a user from the connection, and close it once it's no longer used.
+// The subscription to its pattern is dropped, or replaced when the highest QoS
+// needed by the remaining users of that pattern goes down.
+func (c *mqttConn) remove(u *mqttUser) {
+	mqttPool.Lock()
+	defer mqttPool.Unlock()
+	c.mu.Lock()
+	old, _ := c.qos(u.pattern)
+	delete(c.users, u)
+	qos, shared := c.qos(u.pattern)
+	last := len(c.users) == 0
+	c.mu.Unlock()
+
+	if last {
+		delete(mqttPool.conns, c.broker)
+		close(c.done)
+		if c.client.IsConnected() {
+			c.client.Disconnect(250)
+		}
+	} else if u.pattern != "" && c.client.IsConnected() {
+		switch {
+		case !shared:
+			c.client.Unsubscribe(u.pattern)
+		case qos < old:
+			c.client.Subscribe(u.pattern, qos, c.dispatch(u.pattern))
+		}
 	}
 }
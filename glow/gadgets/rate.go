@@ -0,0 +1,147 @@
+package gadgets
+
+import (
+	"math"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+func init() {
+	glow.Register(glow.Spec{
+		Name: "debounce",
+		Doc:  "Passes on the last message once no new ones have arrived for a while.",
+		Args: []glow.Param{param("quiet", "int", "in milliseconds")},
+		Inlets: []glow.Param{
+			param("in", "any", "the word stop is reserved, it drops the pending message"),
+			cold("quiet", "int", ""),
+		},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			ms := args.At(0).AsInt()
+			var t *glow.Timer
+			var last glow.Message
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			cancel := func() {
+				g.CancelTimer(t)
+				t = nil
+			}
+			g.AddInlet(func(m glow.Message) {
+				cancel()
+				if m.IsString() && m.AsString() == "stop" {
+					return
+				}
+				last = m
+				t = g.SetTimer(ms, func() {
+					t = nil
+					g.Emit(0, last)
+				})
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				ms = m.AsInt()
+			})
+			g.OnStop = cancel
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "throttle",
+		Doc:  "Passes on at most a number of messages per interval, the rest is sent out on the right.",
+		Args: []glow.Param{
+			param("count", "int", "1 if only the interval is given"),
+			param("interval", "int", "in milliseconds"),
+		},
+		Inlets: []glow.Param{
+			param("in", "any", ""),
+			cold("count", "int", ""),
+		},
+		Outlets: []glow.Param{
+			param("out", "any", ""),
+			param("dropped", "any", ""),
+		},
+		New: func(args glow.Message) glow.Gadgetry {
+			count, ms := 1, args.At(0).AsInt()
+			if len(args) > 1 {
+				count, ms = ms, args.At(1).AsInt()
+			}
+			var passed []int // times of the messages passed within the interval
+			g := glow.NewGadget()
+			g.AddOutlets(2)
+			g.AddInlet(func(m glow.Message) {
+				now := g.Now()
+				for len(passed) > 0 && passed[0] <= now-ms {
+					passed = passed[1:]
+				}
+				if len(passed) >= count {
+					g.Emit(1, m)
+					return
+				}
+				passed = append(passed, now)
+				g.Emit(0, m)
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				count = m.AsInt()
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "sample",
+		Doc:  "Holds the last message on the right, and emits it on each message on the left, e.g. a metro tick.",
+		Inlets: []glow.Param{
+			param("tick", "any", "clear drops the held message"),
+			cold("in", "any", ""),
+		},
+		Outlets: []glow.Param{param("out", "any", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			var held glow.Message
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				switch {
+				case m.At(0).AsString() == "clear":
+					held = nil
+				case held != nil:
+					g.Emit(0, held)
+				}
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				held = m
+			})
+			return g
+		},
+	})
+
+	glow.Register(glow.Spec{
+		Name: "deadband",
+		Doc:  "Only passes on numbers which moved more than a threshold away from the last one passed on.",
+		Args: []glow.Param{param("threshold", "number", "")},
+		Inlets: []glow.Param{
+			param("in", "number", "a bang resets, so that the next number passes"),
+			cold("threshold", "number", ""),
+		},
+		Outlets: []glow.Param{param("out", "number", "")},
+		New: func(args glow.Message) glow.Gadgetry {
+			threshold := args.At(0).AsFloat()
+			var last glow.Message
+			g := glow.NewGadget()
+			g.AddOutlets(1)
+			g.AddInlet(func(m glow.Message) {
+				switch {
+				case m.IsBang():
+					last = nil
+				case !m.IsNumber():
+				case last == nil || math.Abs(m.AsFloat()-last.AsFloat()) > threshold:
+					last = m
+					g.Emit(0, m)
+				}
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				threshold = m.AsFloat()
+			})
+			return g
+		},
+	})
+}
@@ -0,0 +1,180 @@
+package gadgets
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+func init() {
+	glow.Register(glow.Spec{
+		Name: "stats",
+		Doc:  "Collects numbers in a window, and emits statistics about them from right to left whenever the window closes.",
+		Args: []glow.Param{
+			param("size", "int", "the number of values, or milliseconds with ms"),
+			param("options", "list", "ms for a time window, sliding to slide instead of tumble, and the statistics: n, min, max, mean, median, stddev, or pNN (default: mean)"),
+		},
+		Inlets: []glow.Param{
+			param("in", "number", "a bang emits the current window, clear empties it"),
+			cold("size", "int", "ignored unless positive"),
+		},
+		Outlets:  []glow.Param{param("out", "number", "one per statistic")},
+		Variadic: true,
+		New: func(args glow.Message) glow.Gadgetry {
+			g := glow.NewGadget()
+			size := args.At(0).AsInt()
+			timed, sliding := false, false
+			var stats []string
+			for i := 1; i < len(args); i++ {
+				switch s := args.At(i).AsString(); s {
+				case "ms":
+					timed = true
+				case "sliding":
+					sliding = true
+				default:
+					if statistic(s, []float64{0}) == nil {
+						g.Fail(fmt.Errorf("unknown statistic: %s", args.At(i)))
+					}
+					stats = append(stats, s)
+				}
+			}
+			if len(stats) == 0 {
+				stats = []string{"mean"}
+			}
+			if size <= 0 {
+				g.Fail(fmt.Errorf("window size must be positive"))
+			}
+			g.AddOutlets(len(stats))
+
+			type sample struct {
+				t int
+				v float64
+			}
+			var window []sample
+			var t *glow.Timer
+			emit := func() {
+				if len(window) == 0 {
+					return
+				}
+				v := make([]float64, len(window))
+				for i, s := range window {
+					v[i] = s.v
+				}
+				sort.Float64s(v)
+				for o := len(stats) - 1; o >= 0; o-- {
+					g.Emit(o, statistic(stats[o], v))
+				}
+			}
+			clear := func() {
+				window = nil
+				if t != nil {
+					g.CancelTimer(t)
+					t = nil
+				}
+			}
+
+			g.AddInlet(func(m glow.Message) {
+				switch {
+				case m.IsBang():
+					emit()
+					return
+				case m.At(0).AsString() == "clear":
+					clear()
+					return
+				case !m.IsNumber():
+					return
+				}
+				now := 0
+				if timed {
+					now = g.Now()
+				}
+				window = append(window, sample{now, m.AsFloat()})
+				switch {
+				case timed && sliding:
+					for len(window) > 0 && window[0].t <= now-size {
+						window = window[1:]
+					}
+					emit()
+				case timed:
+					if t == nil {
+						t = g.SetTimer(size, func() {
+							t = nil
+							emit()
+							window = nil
+						})
+					}
+				case sliding:
+					if len(window) > size {
+						window = window[len(window)-size:]
+					}
+					if len(window) == size {
+						emit()
+					}
+				case len(window) >= size:
+					emit()
+					window = nil
+				}
+			})
+			g.AddColdInlet(func(m glow.Message) {
+				if n := m.AsInt(); n > 0 {
+					size = n
+				}
+			})
+			g.OnStop = clear
+			return g
+		},
+	})
+}
+
+// statistic computes a named statistic of a sorted, non-empty list of values,
+// or returns nil if the name is not known.
+func statistic(name string, v []float64) glow.Message {
+	n := len(v)
+	switch name {
+	case "n":
+		return glow.Message{n}
+	case "min":
+		return glow.Message{v[0]}
+	case "max":
+		return glow.Message{v[n-1]}
+	case "mean":
+		return glow.Message{mean(v)}
+	case "median":
+		return glow.Message{percentile(v, 50)}
+	case "stddev":
+		sum, avg := 0.0, mean(v)
+		for _, x := range v {
+			sum += (x - avg) * (x - avg)
+		}
+		return glow.Message{math.Sqrt(sum / float64(n))}
+	}
+	if strings.HasPrefix(name, "p") {
+		if p, err := strconv.ParseFloat(name[1:], 64); err == nil && p >= 0 && p <= 100 {
+			return glow.Message{percentile(v, p)}
+		}
+	}
+	return nil
+}
+
+// mean returns the average of a list of values.
+func mean(v []float64) float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+// percentile interpolates between the two values closest to the given rank.
+func percentile(v []float64, p float64) float64 {
+	r := p / 100 * float64(len(v)-1)
+	i := int(r)
+	if i+1 >= len(v) {
+		return v[len(v)-1]
+	}
+	return v[i] + (r-float64(i))*(v[i+1]-v[i])
+}
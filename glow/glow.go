@@ -3,14 +3,18 @@
 package glow
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
-// A Message is what gets passed around: a "bang", int, string, or vector.
+// A Message is what gets passed around: a "bang", int, float, bool, string,
+// string-keyed map, or vector.
 type Message []interface{}
 
 // String returns a nice string representation of a message.
@@ -21,29 +25,73 @@ func (m Message) String() string {
 	if m.IsInt() {
 		return fmt.Sprint(m.AsInt())
 	}
+	if m.IsFloat() {
+		return formatFloat(m.AsFloat())
+	}
+	if m.IsBool() {
+		return fmt.Sprint(m.AsBool())
+	}
 	if m.IsString() {
-		s := m.AsString()
-		t := fmt.Sprintf("%q", s)
-		_, e := strconv.Atoi(s)
-		if len(s) == 0 {
-			s = `""`
-		} else if e == nil || len(t) != len(s)+2 || strings.Contains(s, " ") {
-			s = t
+		return quoteIfNeeded(m.AsString())
+	}
+	if m.IsMap() {
+		mv := m[0].(map[string]interface{})
+		keys := make([]string, 0, len(mv))
+		for k := range mv {
+			keys = append(keys, k)
 		}
-		return s
+		sort.Strings(keys)
+		v := []string{}
+		for _, k := range keys {
+			v = append(v, quoteIfNeeded(k), m.Get(k).elementString())
+		}
+		return "{" + strings.Join(v, " ") + "}"
 	}
 	v := []string{}
 	for i := range m {
-		e := m.At(i)
-		s := e.String()
-		if !e.IsBang() && !e.IsInt() && !e.IsString() {
-			s = "[" + s + "]"
-		}
-		v = append(v, s)
+		v = append(v, m.At(i).elementString())
 	}
 	return strings.Join(v, " ")
 }
 
+// elementString represents a message as item of a vector, i.e. with brackets
+// around it if it is itself a vector.
+func (m Message) elementString() string {
+	s := m.String()
+	if !m.isAtom() {
+		s = "[" + s + "]"
+	}
+	return s
+}
+
+// isAtom returns true if m is not a vector.
+func (m Message) isAtom() bool {
+	return m.IsBang() || m.IsInt() || m.IsFloat() || m.IsBool() ||
+		m.IsString() || m.IsMap()
+}
+
+// formatFloat always includes a decimal point or exponent in the result, so
+// that it will be parsed back as float and not as int.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// quoteIfNeeded adds quotes if a string could not be parsed back as is.
+func quoteIfNeeded(s string) string {
+	t := fmt.Sprintf("%q", s)
+	if len(s) == 0 {
+		s = `""`
+	} else if len(t) != len(s)+2 || strings.ContainsAny(s, " []{}") ||
+		parseWord(s) != s {
+		s = t
+	}
+	return s
+}
+
 // At indexes arbitrarily-deeply-nested message structures.
 func (m Message) At(indices ...int) Message {
 	for _, index := range indices {
@@ -63,6 +111,14 @@ func (m Message) At(indices ...int) Message {
 	return m
 }
 
+// Get looks up a key in a map message, returns nil if absent or not a map.
+func (m Message) Get(key string) Message {
+	if !m.IsMap() {
+		return nil
+	}
+	return Message{m[0].(map[string]interface{})[key]}.At(0)
+}
+
 // IsBang returns true if m is a "bang".
 func (m Message) IsBang() bool {
 	return len(m) == 0
@@ -76,6 +132,27 @@ func (m Message) IsInt() (ok bool) {
 	return
 }
 
+// IsFloat returns true if m is a float.
+func (m Message) IsFloat() (ok bool) {
+	if len(m) == 1 {
+		_, ok = m[0].(float64)
+	}
+	return
+}
+
+// IsNumber returns true if m is either an int or a float.
+func (m Message) IsNumber() bool {
+	return m.IsInt() || m.IsFloat()
+}
+
+// IsBool returns true if m is a bool.
+func (m Message) IsBool() (ok bool) {
+	if len(m) == 1 {
+		_, ok = m[0].(bool)
+	}
+	return
+}
+
 // IsString returns true if m is a string.
 func (m Message) IsString() (ok bool) {
 	if len(m) == 1 {
@@ -84,15 +161,45 @@ func (m Message) IsString() (ok bool) {
 	return
 }
 
-// AsInt returns the int in m, else 0.
+// IsMap returns true if m is a map with string keys.
+func (m Message) IsMap() (ok bool) {
+	if len(m) == 1 {
+		_, ok = m[0].(map[string]interface{})
+	}
+	return
+}
+
+// AsInt returns the int in m, truncates a float, else 0.
 func (m Message) AsInt() int {
 	if m.IsInt() {
 		return m[0].(int)
 	}
+	if m.IsFloat() {
+		return int(m[0].(float64))
+	}
 	//fmt.Println("not an int:", m)
 	return 0
 }
 
+// AsFloat returns the float in m, converts an int, else 0.
+func (m Message) AsFloat() float64 {
+	if m.IsFloat() {
+		return m[0].(float64)
+	}
+	if m.IsInt() {
+		return float64(m[0].(int))
+	}
+	return 0
+}
+
+// AsBool returns the bool in m, true for a non-zero number, else false.
+func (m Message) AsBool() bool {
+	if m.IsBool() {
+		return m[0].(bool)
+	}
+	return m.IsNumber() && m.AsFloat() != 0
+}
+
 // AsString returns the string in m, else "".
 func (m Message) AsString() string {
 	if m.IsString() {
@@ -105,23 +212,45 @@ func (m Message) AsString() string {
 // Debug is a Writer for debugging output.
 var Debug io.Writer = os.Stdout
 
-// The Registry is a collection of named gadget constructors.
+// The Registry is a collection of named gadget constructors. Use Register to
+// add new ones, so that they are also described for tools and editors.
 var Registry = map[string]func(args Message) Gadgetry{}
 
 // Gadgetry is the common interface for all gadgets and circuits.
 type Gadgetry interface {
+	base() *Gadget
 	addedTo(*Circuit)
-	Connect(int, Gadgetry, int)
-	Feed(int, Message)
+	removedFrom(*Circuit)
+	Start()
+	Stop()
+	Close()
+	Connect(int, Gadgetry, int) error
+	Feed(int, Message) error
 	Emit(int, Message)
+	Name() string
+	Args() Message
+	NumInlets() int
+	NumOutlets() int
 }
 
-// A Gadget is the base type for all gadgets.
+// A Gadget is the base type for all gadgets. The lifecycle of a gadget is:
+// added to a circuit, started, stopped (this can repeat), and finally closed.
+// Timers, subscriptions, and such should only be active while it is running.
 type Gadget struct {
-	OnAdded func(*Circuit) // called when we've been added to a circuit
-
-	ins  []inlet
-	outs []outlet
+	OnAdded   func(*Circuit) // called when we've been added to a circuit
+	OnStart   func()         // called when we start (or resume) running
+	OnStop    func()         // called when we stop (or pause) running
+	OnRemoved func(*Circuit) // called when we're taken out of a circuit
+
+	name    string  // the name this gadget was created with, if any
+	args    Message // the arguments this gadget was created with
+	record  string  // Pd record type if not an object, e.g. "msg" or "text"
+	ins     []inlet
+	outs    []outlet
+	parent  *Circuit // the circuit we've been added to, if any
+	running bool     // true between Start and Stop
+	closed  bool     // true once Close has been called
+	err     error    // set if the gadget could not be set up properly
 }
 
 // An endpoint is a reference to a specific inlet or outlet in a gadget.
@@ -130,9 +259,21 @@ type endpoint struct {
 	index  int
 }
 
-// An inlet is an endpoint which accepts messages.
+// An inlet is an endpoint which accepts messages. Hot inlets trigger output,
+// cold inlets only store what they get, for use by the next hot message.
 type inlet struct {
 	handler func(m Message)
+	cold    bool
+}
+
+// precedes returns true if ep needs to be fed before x when fanning out from
+// one outlet: cold inlets before hot ones, then from right to left, as in Pd.
+func (ep endpoint) precedes(x endpoint) bool {
+	c1, c2 := ep.gadget.base().ins[ep.index].cold, x.gadget.base().ins[x.index].cold
+	if c1 != c2 {
+		return c1
+	}
+	return ep.index > x.index
 }
 
 // An outlet is an endpoint which publishes messages.
@@ -144,20 +285,53 @@ func NewGadget() *Gadget {
 }
 
 // LookupGadget instantiates a gadget from the registry, with optional args.
+// If the name is not registered, a design with that name is looked up in the
+// DesignPath and instantiated as a circuit instead.
+// Problems found while loading a design are reported on Debug.
 func LookupGadget(name string, args ...interface{}) Gadgetry {
+	g, errs := lookupGadget(name, args, 0)
+	for _, err := range errs {
+		fmt.Fprintln(Debug, err)
+	}
+	return g
+}
+
+// lookupGadget does the actual work for LookupGadget, and for the gadgets of
+// a design, nested depth designs deep. It returns the problems found while
+// loading a design, instead of reporting them.
+func lookupGadget(name string, args Message, depth int) (Gadgetry, DesignError) {
 	r, ok := Registry[name]
 	if !ok {
-		//fmt.Println("unknown gadget:", args)
-		return nil
+		c, errs := lookupDesign(name, args, depth)
+		if c != nil {
+			c.name, c.args = name, args
+			return c, errs
+		}
+		return nil, errs
 	}
-	return r(args)
+	g := r(args)
+	if g != nil {
+		g.base().name, g.base().args = name, args
+	}
+	return g, nil
 }
 
-// AddInlet sets up a new gadget inlet.
+// AddInlet sets up a new hot gadget inlet.
 func (g *Gadget) AddInlet(f func(m Message)) {
 	g.ins = append(g.ins, inlet{handler: f})
 }
 
+// AddColdInlet sets up a new cold gadget inlet, i.e. one which should not
+// generate any output, only change the state of the gadget.
+func (g *Gadget) AddColdInlet(f func(m Message)) {
+	g.ins = append(g.ins, inlet{handler: f, cold: true})
+}
+
+// IsCold returns true if the specified inlet is a cold one.
+func (g *Gadget) IsCold(i int) bool {
+	return i >= 0 && i < len(g.ins) && g.ins[i].cold
+}
+
 // AddOutlets sets up new gadget outlets.
 func (g *Gadget) AddOutlets(n int) int {
 	i := len(g.outs)
@@ -165,212 +339,557 @@ func (g *Gadget) AddOutlets(n int) int {
 	return i
 }
 
+// Fail marks a gadget as unusable, e.g. when its args are not valid. This is
+// how constructors report errors, they are then reported by the loader.
+func (g *Gadget) Fail(err error) {
+	g.err = err
+}
+
+// Err returns the reason why the gadget could not be set up, if any.
+func (g *Gadget) Err() error {
+	return g.err
+}
+
+// Name returns the name this gadget was created with, or the type of record
+// for message boxes, comments, etc. loaded from Pd text (e.g. "msg").
+func (g *Gadget) Name() string {
+	if g.record != "" {
+		return g.record
+	}
+	return g.name
+}
+
+// Args returns a copy of the arguments this gadget was created with.
+func (g *Gadget) Args() Message {
+	return append(Message(nil), g.args...)
+}
+
+// NumInlets returns the number of inlets.
+func (g *Gadget) NumInlets() int {
+	return len(g.ins)
+}
+
+// NumOutlets returns the number of outlets.
+func (g *Gadget) NumOutlets() int {
+	return len(g.outs)
+}
+
+// base returns the underlying gadget, also when embedded in another type.
+func (g *Gadget) base() *Gadget {
+	return g
+}
+
 // addedTo is called when a gadget has been added to a circuit.
 func (g *Gadget) addedTo(c *Circuit) {
+	g.parent = c
 	if g.OnAdded != nil {
 		g.OnAdded(c)
 	}
 }
 
-// Connect adds a connection from a gadget output to a gadget input.
-func (g *Gadget) Connect(o int, d Gadgetry, i int) {
-	g.outs[o] = append(g.outs[o], endpoint{d, i})
+// removedFrom is called when a gadget is taken out of a circuit.
+func (g *Gadget) removedFrom(c *Circuit) {
+	g.Close()
+	for o := range g.outs {
+		g.outs[o] = nil
+	}
+	g.parent = nil
+	if g.OnRemoved != nil {
+		g.OnRemoved(c)
+	}
+}
+
+// Parent returns the circuit this gadget is in, or nil if it has not been
+// added to one, or has been removed from it again.
+func (g *Gadget) Parent() *Circuit {
+	return g.parent
+}
+
+// Post arranges for f to be called on the event loop of the top-level circuit
+// this gadget is in, or right away if it's not in a circuit. This can be used
+// from any goroutine, e.g. to emit messages from an external event.
+func (g *Gadget) Post(f func()) {
+	if g.parent != nil {
+		g.parent.Post(f)
+	} else {
+		f()
+	}
+}
+
+// Start lets a gadget run, unless it is already running or has been closed.
+func (g *Gadget) Start() {
+	if !g.running && !g.closed {
+		g.running = true
+		if g.OnStart != nil {
+			g.OnStart()
+		}
+	}
+}
+
+// Stop pauses a gadget, it can be started again later.
+func (g *Gadget) Stop() {
+	if g.running {
+		g.running = false
+		if g.OnStop != nil {
+			g.OnStop()
+		}
+	}
+}
+
+// Close stops a gadget for good.
+func (g *Gadget) Close() {
+	g.Stop()
+	g.closed = true
+}
+
+// Running returns true if the gadget has been started and not stopped since.
+func (g *Gadget) Running() bool {
+	return g.running
+}
+
+// Connect adds a connection from a gadget output to a gadget input. When an
+// outlet is connected to several inlets, cold inlets are fed first, and then
+// the rest from right to left, so that the hot inlets fire last, as in Pd.
+func (g *Gadget) Connect(o int, d Gadgetry, i int) error {
+	if o < 0 || o >= len(g.outs) {
+		return fmt.Errorf("no outlet %d", o)
+	}
+	if d == nil {
+		return errors.New("no destination gadget")
+	}
+	if i < 0 || i >= len(d.base().ins) {
+		return fmt.Errorf("no inlet %d", i)
+	}
+	ep, out := endpoint{d, i}, g.outs[o]
+	k := len(out)
+	for k > 0 && ep.precedes(out[k-1]) {
+		k--
+	}
+	g.outs[o] = append(out[:k:k], append(outlet{ep}, out[k:]...)...)
+	return nil
+}
+
+// Disconnect removes a connection from a gadget output to a gadget input.
+func (g *Gadget) Disconnect(o int, d Gadgetry, i int) error {
+	if o >= 0 && o < len(g.outs) {
+		for k, ep := range g.outs[o] {
+			if ep.gadget == d && ep.index == i {
+				g.outs[o] = append(g.outs[o][:k:k], g.outs[o][k+1:]...)
+				return nil
+			}
+		}
+	}
+	return errors.New("no such connection")
 }
 
 // Feed accepts a message for a specific inlet (indexed from 0 upwards).
-func (g *Gadget) Feed(i int, m Message) {
+func (g *Gadget) Feed(i int, m Message) error {
+	if i < 0 || i >= len(g.ins) {
+		return fmt.Errorf("no inlet %d", i)
+	}
 	g.ins[i].handler(m)
+	return nil
 }
 
-// Emit sends a message to a specific outlet (indexed from 0 upwards).
+// Emit sends a message to a specific outlet (indexed from 0 upwards). Each
+// message is processed depth-first, i.e. Emit returns once all gadgets which
+// are connected downstream are done. Gadgets which emit on several outlets
+// at once should do so from right to left, as in Pd. Messages sent to an
+// outlet which doesn't exist are ignored.
 func (g *Gadget) Emit(o int, m Message) {
+	if o < 0 || o >= len(g.outs) {
+		return
+	}
 	for _, ep := range g.outs[o] {
 		ep.gadget.Feed(ep.index, m)
 	}
 }
 
-// A Circuit is a composition of gadgets, including sub-circuits.
+// A Circuit is a composition of gadgets, including sub-circuits. All the
+// activity in a circuit should be serialised, i.e. gadgets do not have to deal
+// with concurrency. Use Post to inject events from other goroutines.
 type Circuit struct {
 	Gadget
 	Notifier
 
-	gadgets []Gadgetry
+	gadgets  []Gadgetry
+	id       int         // used to expand "$0" in names, see Expand
+	globals  []*listener // only used in the top-level circuit
+	loop     Loop        // only used in the top-level circuit
+	timeline timeline    // only used in the top-level circuit
 }
 
-// NewCircuit creates a new empty circuit
+// NewCircuit creates a new empty circuit, it starts out in running state.
 func NewCircuit() *Circuit {
 	c := new(Circuit)
 	c.Notifier = MakeNotifier()
+	c.id = int(atomic.AddInt32(&lastCircuitId, 1))
+	c.timeline.setup()
+	c.running = true
 	return c
 }
 
-// Add a new gadget (or sub-circuit) to a circuit.
-func (c *Circuit) Add(g Gadgetry) {
-	c.gadgets = append(c.gadgets, g)
-	g.addedTo(c)
+// addedTo moves all pending timers and global subscriptions to the new
+// top-level circuit, since the circuit is no longer a top-level one itself.
+func (c *Circuit) addedTo(p *Circuit) {
+	c.timeline.moveTo(&p.Root().timeline)
+	c.moveGlobals(p.Root())
+	c.Gadget.addedTo(p)
 }
 
-// AddWire adds a connection from one gadget's outlet to another's inlet.
-func (c *Circuit) AddWire(srcg, srco, dstg, dsti int) {
-	c.gadgets[srcg].Connect(srco, c.gadgets[dstg], dsti)
+// Root returns the top-level circuit, i.e. the one which is not inside another.
+func (c *Circuit) Root() *Circuit {
+	for c.parent != nil {
+		c = c.parent
+	}
+	return c
 }
 
-// ParseAsMessage parses a string and returns a message constructed from it.
-func ParseAsMessage(s string) (m Message) {
-	for _, x := range strings.Split(s, " ") {
-		if v, e := strconv.Atoi(x); e == nil {
-			m = append(m, v)
-		} else {
-			m = append(m, x)
+// Loop returns the event loop of the top-level circuit.
+func (c *Circuit) Loop() *Loop {
+	return &c.Root().loop
+}
+
+// Post arranges for f to be called on the event loop of the top-level circuit.
+func (c *Circuit) Post(f func()) {
+	c.Loop().Post(f)
+}
+
+// Start lets the circuit run, and starts all its gadgets in order.
+func (c *Circuit) Start() {
+	if !c.closed {
+		c.Gadget.Start()
+		for _, g := range c.gadgets {
+			if g != nil {
+				g.Start()
+			}
 		}
 	}
-	return
 }
 
-// NewCircuitFromText constructs a circuit from a Pd text representation.
-func NewCircuitFromText(text string) Gadgetry {
-	c := NewCircuit()
-	for _, s := range strings.Split(text, "\n") {
-		if strings.HasPrefix(s, "#X ") && strings.HasSuffix(s, ";") {
-			m := ParseAsMessage(s[3 : len(s)-1])
-			switch m[0] {
-			case "obj":
-				c.Add(LookupGadget(m[3].(string), m[4:]...))
-			case "connect":
-				c.AddWire(m[1].(int), m[2].(int), m[3].(int), m[4].(int))
-			}
+// Stop pauses the circuit, and stops all its gadgets in reverse order.
+func (c *Circuit) Stop() {
+	for i := len(c.gadgets) - 1; i >= 0; i-- {
+		if g := c.gadgets[i]; g != nil {
+			g.Stop()
 		}
 	}
-	return c
+	c.Gadget.Stop()
+}
+
+// Close shuts the circuit down for good, and closes all its gadgets in reverse
+// order. This releases all timers and other resources held by the gadgets.
+func (c *Circuit) Close() {
+	for i := len(c.gadgets) - 1; i >= 0; i-- {
+		if g := c.gadgets[i]; g != nil {
+			g.Close()
+		}
+	}
+	c.Gadget.Close()
 }
 
-// A listener responds to notifications.
-type listener struct {
-	callback func(Message)
-	topic    string
-	periodic bool
+// attach sets up a gadget which has just been put in the circuit, and makes
+// it run if the circuit is running.
+func (c *Circuit) attach(g Gadgetry) {
+	g.addedTo(c)
+	if c.running {
+		g.Start()
+	} else {
+		g.Stop()
+	}
 }
 
-// A Notifier calls listeners interested in a topic or after a timeout.
-type Notifier map[string][]*listener
+// Add a new gadget (or sub-circuit) to a circuit.
+func (c *Circuit) Add(g Gadgetry) error {
+	if g == nil {
+		return errors.New("cannot add a nil gadget")
+	}
+	c.gadgets = append(c.gadgets, g)
+	c.attach(g)
+	return nil
+}
 
-// MakeNotifier returns a freshly initialised notifier.
-func MakeNotifier() Notifier {
-	return make(Notifier)
+// Gadgets returns all the gadgets in a circuit, in the order they were added.
+// Removed gadgets are returned as nil, so that indices remain the same.
+func (c *Circuit) Gadgets() []Gadgetry {
+	return append([]Gadgetry(nil), c.gadgets...)
 }
 
-// On subscribes to a specific topic.
-func (nf Notifier) On(s string, f func(Message)) *listener {
-	e := &listener{callback: f, topic: s}
-	lv, _ := nf[s]
-	nf[s] = append(lv, e)
-	return e
+// A Wire is a connection from one gadget's outlet to another's inlet. The
+// gadgets are identified by their index in the circuit.
+type Wire struct {
+	From, Outlet, To, Inlet int
 }
 
-// Off unsubscribes an existing listener.
-func (nf Notifier) Off(l *listener) {
-	lv := nf[l.topic]
-	for i, x := range lv {
-		if l == x {
-			n := copy(lv[i+1:], lv[i:])
-			lv = lv[:i+n]
+// Wires returns all the connections between gadgets inside a circuit.
+func (c *Circuit) Wires() (wires []Wire) {
+	index := map[Gadgetry]int{}
+	for i, g := range c.gadgets {
+		if g != nil {
+			index[g] = i
 		}
 	}
-	if len(lv) > 0 {
-		nf[l.topic] = lv
-	} else {
-		delete(nf, l.topic)
+	for i, g := range c.gadgets {
+		if g == nil {
+			continue
+		}
+		for o, out := range g.base().outs {
+			for _, ep := range out {
+				if j, ok := index[ep.gadget]; ok {
+					wires = append(wires, Wire{i, o, j, ep.index})
+				}
+			}
+		}
 	}
+	return
 }
 
-// Notify informs all listeners of a specific topic.
-func (nf Notifier) Notify(s string, args ...interface{}) {
-	l, _ := nf[s]
-	for _, e := range l {
-		e.callback(args)
+// AddWire adds a connection from one gadget's outlet to another's inlet.
+func (c *Circuit) AddWire(srcg, srco, dstg, dsti int) error {
+	err := c.check(srcg, dstg)
+	if err == nil {
+		err = c.gadgets[srcg].Connect(srco, c.gadgets[dstg], dsti)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot wire %d/%d to %d/%d: %v",
+			srcg, srco, dstg, dsti, err)
 	}
+	return err
 }
 
-// Now is the current time, either real or simulated.
-var Now int
+// RemoveWire drops a connection between two gadgets in the circuit.
+func (c *Circuit) RemoveWire(srcg, srco, dstg, dsti int) error {
+	err := c.check(srcg, dstg)
+	if err == nil {
+		err = c.gadgets[srcg].base().Disconnect(srco, c.gadgets[dstg], dsti)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot unwire %d/%d from %d/%d: %v",
+			srcg, srco, dstg, dsti, err)
+	}
+	return err
+}
 
-// The timers map keeps track of all global timeout listeners.
-var timers Notifier
+// RemoveGadget takes a gadget out of the circuit, after dropping all its wires.
+// The slot is left empty, the indices of all other gadgets remain the same.
+func (c *Circuit) RemoveGadget(i int) error {
+	if err := c.check(i); err != nil {
+		return err
+	}
+	g := c.gadgets[i]
+	c.unwire(g)
+	c.gadgets[i] = nil
+	g.removedFrom(c)
+	return nil
+}
+
+// Replace swaps a gadget in the circuit for another one, at the same index.
+// All wires are kept, except those to inlets or outlets which no longer exist.
+func (c *Circuit) Replace(i int, g Gadgetry) error {
+	if err := c.check(i); err != nil {
+		return err
+	}
+	if g == nil {
+		return errors.New("cannot add a nil gadget")
+	}
+	wires := c.Wires()
+	old := c.gadgets[i]
+	c.unwire(old)
+	old.removedFrom(c)
+	c.gadgets[i] = g
+	c.attach(g)
+	for _, w := range wires {
+		if w.From == i || w.To == i {
+			c.AddWire(w.From, w.Outlet, w.To, w.Inlet)
+		}
+	}
+	return nil
+}
 
-// NextTimer is set to the lowest pending timer value, else -1.
-var NextTimer int
+// check verifies that all the specified gadget indices are valid.
+func (c *Circuit) check(indices ...int) error {
+	for _, i := range indices {
+		if i < 0 || i >= len(c.gadgets) || c.gadgets[i] == nil {
+			return fmt.Errorf("no gadget %d", i)
+		}
+	}
+	return nil
+}
 
-// TODO could use a string and fixed-length numbers to avoid many conversions
-// listeners could even be combined with another notifier if prefixed by "t:"
+// unwire drops all connections from and to a gadget in the circuit.
+func (c *Circuit) unwire(g Gadgetry) {
+	for _, x := range c.gadgets {
+		if x == nil {
+			continue
+		}
+		outs := x.base().outs
+		for o, out := range outs {
+			var keep outlet
+			for _, ep := range out {
+				if ep.gadget != g {
+					keep = append(keep, ep)
+				}
+			}
+			outs[o] = keep
+		}
+	}
+	for o := range g.base().outs {
+		g.base().outs[o] = nil
+	}
+}
 
-// Run advances (real or simulated) time and triggers all timers as scheduled.
-func Run(ms int) {
-	tlimit := Now + ms
-	for NextTimer >= 0 && NextTimer <= tlimit {
-		Now = NextTimer                // this is where simulated time advances
-		timers.Notify(fmt.Sprint(Now)) // fire all the matching pending timers
-		lookForNextTimer()             // figure out when next timer must run
+// removedFrom takes all gadgets out of a circuit which is itself being taken
+// out of its parent, so that they can clean up (e.g. cancel their timers).
+func (c *Circuit) removedFrom(p *Circuit) {
+	for i := len(c.gadgets) - 1; i >= 0; i-- {
+		if g := c.gadgets[i]; g != nil {
+			g.removedFrom(c)
+		}
 	}
-	Now = tlimit // final time jump
+	c.Gadget.removedFrom(p)
 }
 
-// Stop will cancel all pending timers, used to simplify testing
-func Stop() {
-	timers = MakeNotifier()
-	NextTimer = -1
+// ParseAsMessage parses a string and returns a message constructed from it.
+// This is the inverse of Message.String: words are converted to ints, floats,
+// and bools where possible, quoted strings are unquoted, "[...]" turns into a
+// nested vector and "{...}" into a map. A lone "[]" is parsed as a bang, and
+// an empty string as a message with an empty string, as it always has been.
+func ParseAsMessage(s string) Message {
+	if s == "" {
+		return Message{""}
+	}
+	p := &messageParser{s: s}
+	m := p.parseItems(0)
+	if len(m) == 1 && m[0] == nil && strings.TrimSpace(s) == "[]" {
+		m = Message{}
+	}
+	return m
 }
 
-func init() {
-	Stop()
+// A messageParser tracks the parsing state of a textual message.
+type messageParser struct {
+	s   string
+	pos int
 }
 
-// lookForNextTimer scans the timers to find the first pending one.
-func lookForNextTimer() {
-	NextTimer = -1
-	for topic := range timers {
-		t, _ := strconv.Atoi(topic)
-		fixNextTimer(t)
+// parseItems collects items until the closing char or end of text is reached.
+func (p *messageParser) parseItems(close byte) (m Message) {
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return
+		}
+		c := p.s[p.pos]
+		if close != 0 && c == close {
+			p.pos++
+			return
+		}
+		m = append(m, p.parseItem())
 	}
 }
 
-// adjustNextTimer updates the next timeout we need to process.
-func fixNextTimer(t int) {
-	if t < NextTimer || NextTimer < 0 {
-		NextTimer = t
+// parseItem parses a single vector element.
+func (p *messageParser) parseItem() interface{} {
+	switch p.s[p.pos] {
+	case '[':
+		p.pos++
+		if v := p.parseItems(']'); len(v) > 0 {
+			return v
+		}
+		return nil
+	case '{':
+		p.pos++
+		v := p.parseItems('}')
+		mv := map[string]interface{}{}
+		for i := 0; i+1 < len(v); i += 2 {
+			k, ok := v[i].(string)
+			if !ok {
+				k = Message{v[i]}.String()
+			}
+			mv[k] = v[i+1]
+		}
+		return mv
+	case '"':
+		if s, ok := p.parseQuoted(); ok {
+			return s
+		}
 	}
+	start := p.pos
+	for p.pos < len(p.s) && !isSpace(p.s[p.pos]) &&
+		(p.pos == start || strings.IndexByte("[]{}", p.s[p.pos]) < 0) {
+		p.pos++
+	}
+	return parseWord(p.s[start:p.pos])
+}
+
+// parseQuoted scans a double-quoted string, using Go's escape conventions.
+func (p *messageParser) parseQuoted() (string, bool) {
+	for i := p.pos + 1; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '\\':
+			i++
+		case '"':
+			s, err := strconv.Unquote(p.s[p.pos : i+1])
+			if err != nil {
+				return "", false
+			}
+			p.pos = i + 1
+			return s, true
+		}
+	}
+	return "", false
 }
 
-// use a separate type for timer listeners to avoid mixups
-type timer listener
-
-// SetTimer schedules a one-shot notification.
-func SetTimer(ms int, f func()) *timer {
-	tsched := Now + ms
-	var t *timer
-	t = (*timer)(timers.On(fmt.Sprint(tsched), func(Message) {
-		CancelTimer(t)
-		if t.periodic {
-			SetPeriodic(ms, f)
-		}
-		f()
-	}))
-	fixNextTimer(tsched)
-	return t
+// skipSpace moves past all white space.
+func (p *messageParser) skipSpace() {
+	for p.pos < len(p.s) && isSpace(p.s[p.pos]) {
+		p.pos++
+	}
 }
 
-// SetPeriodic schedules a repeating notification.
-func SetPeriodic(ms int, f func()) *timer {
-	t := SetTimer(ms, f)
-	t.periodic = true
-	return t
+// isSpace returns true for the white space characters separating words.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
 }
 
-// CancelTimer drops a pending timer notification.
-func CancelTimer(t *timer) {
-	timers.Off((*listener)(t))
-	// make sure NextTimer remains valid
-	t1, _ := strconv.Atoi(t.topic)
-	if t1 == NextTimer {
-		lookForNextTimer()
+// parseWord converts a word to an int, float, or bool if possible.
+func parseWord(s string) interface{} {
+	if v, e := strconv.Atoi(s); e == nil {
+		return v
+	}
+	switch s {
+	case "+Inf", "-Inf", "NaN": // as produced by formatFloat
+		v, _ := strconv.ParseFloat(s, 64)
+		return v
+	}
+	if isFloat(s) {
+		if v, e := strconv.ParseFloat(s, 64); e == nil {
+			return v
+		}
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return s
+}
+
+// isFloat checks for a plain decimal float, ParseFloat also accepts things
+// such as "inf", "nan", and hex notation which should remain strings. Only
+// the exact forms "+Inf", "-Inf", and "NaN" are handled, by parseWord.
+func isFloat(s string) bool {
+	digits := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '.' || c == '-' || c == '+':
+		case (c == 'e' || c == 'E') && digits:
+		default:
+			return false
+		}
 	}
+	return digits
 }
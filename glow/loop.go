@@ -0,0 +1,85 @@
+package glow
+
+import (
+	"context"
+	"sync"
+)
+
+// A Loop serialises work which can be posted from any goroutine. Each piece
+// of work runs to completion before the next one is started, in the order in
+// which they were posted. Normally, this happens on the goroutine which posts
+// to an idle loop, but when Serve is running, it is always done by Serve.
+type Loop struct {
+	mu    sync.Mutex
+	queue []func()
+	busy  bool          // true while some goroutine is running the queue
+	wake  chan struct{} // only set while Serve is running
+}
+
+// Post adds work to the end of the queue. It returns once the queue is empty
+// if the loop was idle, else it returns right away.
+func (l *Loop) Post(f func()) {
+	l.mu.Lock()
+	l.queue = append(l.queue, f)
+	if l.busy || l.wake != nil {
+		if l.wake != nil {
+			select {
+			case l.wake <- struct{}{}:
+			default: // already signalled
+			}
+		}
+		l.mu.Unlock()
+		return
+	}
+	l.busy = true
+	l.mu.Unlock()
+	l.drain()
+}
+
+// drain runs all queued work, including whatever gets posted meanwhile.
+func (l *Loop) drain() {
+	for {
+		l.mu.Lock()
+		if len(l.queue) == 0 {
+			l.busy = false
+			l.mu.Unlock()
+			return
+		}
+		f := l.queue[0]
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+		l.mu.Unlock()
+		f()
+	}
+}
+
+// Serve runs all posted work on the current goroutine, until the context is
+// cancelled. Work which is still pending at that point remains queued.
+func (l *Loop) Serve(ctx context.Context) {
+	wake := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.wake = wake
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.wake = nil
+		l.mu.Unlock()
+	}()
+
+	for {
+		l.mu.Lock()
+		idle := !l.busy && len(l.queue) > 0
+		if idle {
+			l.busy = true
+		}
+		l.mu.Unlock()
+		if idle {
+			l.drain()
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
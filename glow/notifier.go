@@ -0,0 +1,102 @@
+package glow
+
+import "strings"
+
+// A listener responds to notifications.
+type listener struct {
+	callback func(Message)
+	topic    string
+}
+
+// A topicNode is one level in the trie of topics, as separated by slashes.
+type topicNode struct {
+	children  map[string]*topicNode
+	listeners []*listener
+}
+
+// A Notifier calls listeners interested in a topic. Topics are hierarchical,
+// with levels separated by "/". Subscriptions can use MQTT-style wildcards:
+// "+" matches exactly one level, and "#" as final level matches all the rest.
+type Notifier struct {
+	root *topicNode
+}
+
+// MakeNotifier returns a freshly initialised notifier.
+func MakeNotifier() Notifier {
+	return Notifier{root: &topicNode{}}
+}
+
+// On subscribes to a topic, which may contain wildcards.
+func (nf Notifier) On(s string, f func(Message)) *listener {
+	e := &listener{callback: f, topic: s}
+	nf.add(e)
+	return e
+}
+
+// add inserts a listener in the trie, at the node for its topic.
+func (nf Notifier) add(e *listener) {
+	n := nf.root
+	for _, level := range strings.Split(e.topic, "/") {
+		if n.children == nil {
+			n.children = map[string]*topicNode{}
+		}
+		c := n.children[level]
+		if c == nil {
+			c = &topicNode{}
+			n.children[level] = c
+		}
+		n = c
+	}
+	n.listeners = append(n.listeners, e)
+}
+
+// Off unsubscribes an existing listener.
+func (nf Notifier) Off(l *listener) {
+	nf.root.remove(l, strings.Split(l.topic, "/"))
+}
+
+// remove drops a listener and prunes nodes which are no longer needed.
+// It returns true when this node has become empty.
+func (n *topicNode) remove(l *listener, levels []string) bool {
+	if len(levels) > 0 {
+		c := n.children[levels[0]]
+		if c != nil && c.remove(l, levels[1:]) {
+			delete(n.children, levels[0])
+		}
+	} else {
+		var lv []*listener // a new slice, in case Notify is iterating over it
+		for _, x := range n.listeners {
+			if l != x {
+				lv = append(lv, x)
+			}
+		}
+		n.listeners = lv
+	}
+	return len(n.listeners) == 0 && len(n.children) == 0
+}
+
+// Notify informs all listeners with a subscription matching this topic.
+func (nf Notifier) Notify(s string, args ...interface{}) {
+	var lv []*listener
+	nf.root.match(strings.Split(s, "/"), &lv)
+	for _, e := range lv {
+		e.callback(args)
+	}
+}
+
+// match collects the listeners of all nodes matching the remaining levels.
+func (n *topicNode) match(levels []string, lv *[]*listener) {
+	if c := n.children["#"]; c != nil {
+		*lv = append(*lv, c.listeners...) // also matches the parent level
+	}
+	if len(levels) == 0 {
+		*lv = append(*lv, n.listeners...)
+		return
+	}
+	if c := n.children[levels[0]]; c != nil {
+		c.match(levels[1:], lv)
+	}
+	if c := n.children["+"]; c != nil && levels[0] != "+" {
+		c.match(levels[1:], lv)
+	}
+}
@@ -0,0 +1,429 @@
+package glow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A pdRecord is one ";"-terminated entry in a Pd text file.
+type pdRecord struct {
+	line  int     // line number on which this record starts
+	atoms Message // all atoms up to the first unescaped comma
+}
+
+// parsePd splits Pd text into records. Records can span multiple lines, and
+// escaped characters ("\;", "\,", "\$", "\\", and "\ ") are taken literally.
+func parsePd(text string) (recs []pdRecord) {
+	var rec pdRecord
+	var atom []byte
+	inAtom, escaped, dropping := false, false, false
+	line := 1
+
+	endAtom := func() {
+		if inAtom && !dropping {
+			rec.atoms = append(rec.atoms, pdAtom(string(atom), escaped))
+		}
+		atom, inAtom, escaped = atom[:0], false, false
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			i++
+			if text[i] == '\n' {
+				line++
+			}
+			if !inAtom && rec.line == 0 {
+				rec.line = line
+			}
+			atom = append(atom, text[i])
+			inAtom, escaped = true, true
+		case c == ';':
+			endAtom()
+			if len(rec.atoms) > 0 {
+				recs = append(recs, rec)
+			}
+			rec, dropping = pdRecord{}, false
+		case c == ',':
+			endAtom()
+			dropping = true // ignore trailing parts, such as ", f 10"
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			endAtom()
+			if c == '\n' {
+				line++
+			}
+		default:
+			if rec.line == 0 {
+				rec.line = line
+			}
+			atom = append(atom, c)
+			inAtom = true
+		}
+	}
+	return
+}
+
+// pdAtom converts a Pd atom to an int or float if possible, else a string.
+// Atoms with escaped characters in them are always treated as strings.
+func pdAtom(s string, escaped bool) interface{} {
+	if !escaped {
+		if v, e := strconv.Atoi(s); e == nil {
+			return v
+		}
+		if isFloat(s) {
+			if v, e := strconv.ParseFloat(s, 64); e == nil {
+				return v
+			}
+		}
+	}
+	return s
+}
+
+// pdName returns an atom as gadget name, even if it looks like a number.
+func pdName(m Message) string {
+	if m.IsString() {
+		return m.AsString()
+	}
+	return m.String()
+}
+
+// A DesignError lists all the problems found while loading a design.
+type DesignError []error
+
+// Error returns all the problems, one per line.
+func (e DesignError) Error() string {
+	v := make([]string, len(e))
+	for i, err := range e {
+		v[i] = err.Error()
+	}
+	return strings.Join(v, "\n")
+}
+
+// ParseCircuit constructs a circuit from a Pd text representation. It fails
+// with a DesignError if there are unknown gadgets, gadgets which failed to be
+// set up (see Gadget.Fail), invalid wires, or malformed records, each reported
+// with the line number where it occurs.
+func ParseCircuit(text string) (*Circuit, error) {
+	c, errs := loadPd(parsePd(text), 0)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// NewCircuitFromText constructs a circuit from a Pd text representation.
+// Subpatches become nested circuits. Message boxes, atom boxes, and comments
+// are added as gadgets as well, so that object numbering matches Pd's.
+// This is the lenient version of ParseCircuit: problems are reported on Debug,
+// unknown gadgets are replaced by inert ones, and invalid wires are skipped.
+func NewCircuitFromText(text string) Gadgetry {
+	c, errs := loadPd(parsePd(text), 0)
+	for _, err := range errs {
+		fmt.Fprintln(Debug, err)
+	}
+	return c
+}
+
+// loadPd does the actual work for ParseCircuit, NewCircuitFromText, and
+// instantiating designs by name. The depth is the number of designs the
+// records are nested in. Problems in the designs they use are included, with
+// the line where each design is used.
+func loadPd(recs []pdRecord, depth int) (*Circuit, DesignError) {
+	var errs DesignError
+	fail := func(line int, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		errs = append(errs, fmt.Errorf("line %d: %s", line, msg))
+	}
+
+	stack := []*Circuit{NewCircuit()}
+	starts := []int{0} // line numbers where each canvas starts
+	canvases := 0
+	for _, r := range recs {
+		c := stack[len(stack)-1]
+		m := r.atoms
+		kind := m.At(0).AsString() + " " + m.At(1).AsString()
+		switch kind {
+		case "#X obj", "#X msg", "#X floatatom", "#X symbolatom",
+			"#X listbox", "#X text":
+			if len(m) < 4 || !m.At(2).IsNumber() || !m.At(3).IsNumber() {
+				fail(r.line, "malformed record: %s", m)
+				g := newComment() // keep the numbering intact
+				g.record = "obj"
+				c.Add(g)
+				continue
+			}
+		}
+		switch kind {
+		case "#N canvas":
+			if canvases > 0 {
+				sub := NewCircuit()
+				sub.id = stack[0].id // a subpatch shares "$0" with its design
+				stack = append(stack, sub)
+				starts = append(starts, r.line)
+			}
+			canvases++
+		case "#X restore":
+			if len(stack) > 1 {
+				if len(m) >= 5 {
+					c.name, c.args = pdName(m.At(4)), m[5:]
+				} else {
+					fail(r.line, "malformed record: %s", m) // keep it nested
+				}
+				stack = stack[:len(stack)-1]
+				starts = starts[:len(starts)-1]
+				stack[len(stack)-1].Add(c)
+			} else {
+				fail(r.line, "restore without canvas")
+			}
+		case "#X obj":
+			if len(m) > 4 {
+				name := pdName(m.At(4))
+				g, nested := lookupGadget(name, m[5:], depth)
+				for _, err := range nested {
+					fail(r.line, "%s: %v", name, err)
+				}
+				switch {
+				case g == nil && nested == nil:
+					fail(r.line, "unknown gadget: %s", name)
+				case g != nil && g.base().err != nil:
+					fail(r.line, "%s: %v", name, g.base().err)
+					g = nil
+				}
+				if g == nil {
+					g = newComment()
+					g.base().name, g.base().args = name, m[5:]
+				}
+				c.Add(g)
+			} else {
+				g := newComment() // an empty object box
+				g.record = "obj"
+				c.Add(g)
+			}
+		case "#X msg":
+			var g Gadgetry
+			if f, ok := Registry["msg"]; ok {
+				g = f(m[4:])
+			} else {
+				g = newMessageBox(m[4:])
+			}
+			g.base().record, g.base().args = "msg", m[4:]
+			c.Add(g)
+		case "#X floatatom", "#X symbolatom", "#X listbox":
+			g := newAtomBox()
+			g.record, g.args = m.At(1).AsString(), m[4:]
+			c.Add(g)
+		case "#X text":
+			g := newComment()
+			g.record, g.args = "text", m[4:]
+			c.Add(g)
+		case "#X connect":
+			if len(m) != 6 || !m[2:].isIntVector() {
+				fail(r.line, "malformed record: %s", m)
+			} else if err := c.AddWire(m.At(2).AsInt(), m.At(3).AsInt(),
+				m.At(4).AsInt(), m.At(5).AsInt()); err != nil {
+				fail(r.line, "%v", err)
+			}
+		default:
+			if !strings.HasPrefix(kind, "#") {
+				fail(r.line, "malformed record: %s", m)
+			}
+		}
+	}
+	for i := len(starts) - 1; i > 0; i-- {
+		fail(starts[i], "canvas without restore")
+	}
+	return stack[0], errs
+}
+
+// isIntVector returns true if all elements of a message are ints.
+func (m Message) isIntVector() bool {
+	for i := range m {
+		if !m.At(i).IsInt() {
+			return false
+		}
+	}
+	return true
+}
+
+// newComment returns an inert gadget, without any inlets or outlets.
+func newComment() *Gadget {
+	return NewGadget()
+}
+
+// newMessageBox returns a gadget which sends out its content when triggered.
+func newMessageBox(content Message) *Gadget {
+	g := NewGadget()
+	g.AddOutlets(1)
+	g.AddInlet(func(m Message) {
+		g.Emit(0, content.StripSelector())
+	})
+	return g
+}
+
+// StripSelector applies Pd's rules for the first word of a message box: just
+// "bang" is a bang, and a leading "float", "symbol", or "list" is dropped,
+// i.e. "list a b" is the same as "a b".
+func (m Message) StripSelector() Message {
+	switch m.At(0).AsString() {
+	case "bang":
+		if len(m) == 1 {
+			return nil
+		}
+	case "float", "symbol", "list":
+		return m[1:]
+	}
+	return m
+}
+
+// newAtomBox returns a gadget which passes on and remembers its input, and
+// sends out the last value again when it receives a bang.
+func newAtomBox() *Gadget {
+	var last Message
+	g := NewGadget()
+	g.AddOutlets(1)
+	g.AddInlet(func(m Message) {
+		if !m.IsBang() {
+			last = m
+		}
+		g.Emit(0, last)
+	})
+	return g
+}
+
+// WriteText saves a circuit as Pd text, which can then be edited with Pd and
+// loaded back in with ParseCircuit. Sub-circuits are written as subpatches,
+// unless they were created by name. Since gadgets don't keep track of their
+// position, a simple layout is generated from the way they are wired up.
+func (c *Circuit) WriteText(w io.Writer) error {
+	var b bytes.Buffer
+	b.WriteString("#N canvas 0 50 450 300 10;\n")
+	if err := c.writePd(&b); err != nil {
+		return err
+	}
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// writePd emits the records for all the gadgets and wires in a circuit.
+func (c *Circuit) writePd(b *bytes.Buffer) error {
+	// leave out removed gadgets, and renumber the rest
+	var gadgets []Gadgetry
+	index := map[int]int{}
+	for i, g := range c.gadgets {
+		if g != nil {
+			index[i] = len(gadgets)
+			gadgets = append(gadgets, g)
+		}
+	}
+	wires := c.Wires()
+	for i, w := range wires {
+		wires[i].From, wires[i].To = index[w.From], index[w.To]
+	}
+	xs, ys := layout(len(gadgets), wires)
+
+	for i, g := range gadgets {
+		gb := g.base()
+		x, y := xs[i], ys[i]
+		atoms := append(Message{gb.name}, gb.args...)
+		switch sub, ok := g.(*Circuit); {
+		case gb.record != "":
+			fmt.Fprintf(b, "#X %s %d %d", gb.record, x, y)
+			atoms = gb.args
+		case ok && (gb.name == "" || gb.name == "pd"):
+			name := "(subpatch)"
+			if gb.args.At(0).IsString() {
+				name = gb.args.At(0).AsString()
+			}
+			fmt.Fprintf(b, "#N canvas 0 50 450 300 %s 0;\n", pdEscape(name))
+			if err := sub.writePd(b); err != nil {
+				return err
+			}
+			fmt.Fprintf(b, "#X restore %d %d", x, y)
+			atoms = append(Message{"pd"}, gb.args...)
+		case gb.name == "":
+			return fmt.Errorf("gadget %d has no name", i)
+		default:
+			fmt.Fprintf(b, "#X obj %d %d", x, y)
+		}
+		for _, a := range atoms {
+			s, err := pdAtomText(a)
+			if err != nil {
+				return fmt.Errorf("gadget %d: %v", i, err)
+			}
+			b.WriteString(" " + s)
+		}
+		b.WriteString(";\n")
+	}
+
+	for _, w := range wires {
+		fmt.Fprintf(b, "#X connect %d %d %d %d;\n", w.From, w.Outlet, w.To, w.Inlet)
+	}
+	return nil
+}
+
+// layout places gadgets in rows, according to their distance from the start
+// of the message flow, without going into loops.
+func layout(n int, wires []Wire) (xs, ys []int) {
+	depth := make([]int, n)
+	for pass := 0; pass < n; pass++ {
+		changed := false
+		for _, w := range wires {
+			if depth[w.To] <= depth[w.From] && depth[w.From] < n-1 {
+				depth[w.To] = depth[w.From] + 1
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	xs, ys = make([]int, n), make([]int, n)
+	count := map[int]int{}
+	for i, d := range depth {
+		xs[i] = 20 + 120*count[d]
+		ys[i] = 20 + 40*d
+		count[d]++
+	}
+	return
+}
+
+// pdAtomText converts an atom to Pd text, escaping it where needed.
+func pdAtomText(a interface{}) (string, error) {
+	switch v := a.(type) {
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return formatFloat(v), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case string:
+		if v == "" {
+			return "", fmt.Errorf("cannot represent empty symbol in Pd")
+		}
+		s := pdEscape(v)
+		if pdAtom(s, false) != s {
+			s = "\\" + s // keep it a symbol, even if it looks like a number
+		}
+		return s, nil
+	}
+	return "", fmt.Errorf("cannot represent %s in Pd", Message{a})
+}
+
+// pdEscape puts a backslash in front of all special characters.
+func pdEscape(s string) string {
+	var b bytes.Buffer
+	for _, c := range []byte(s) {
+		if strings.IndexByte(" \t\n;,$\\", c) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
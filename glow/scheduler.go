@@ -0,0 +1,83 @@
+package glow
+
+import "container/heap"
+
+// A Timer is a pending timeout, created with SetTimer or SetPeriodic.
+type Timer struct {
+	tl       *timeline // the timeline this timer was last scheduled on
+	callback func()
+	period   int    // repeat interval, only used for periodic timers
+	due      int    // the time when this timer is scheduled to fire
+	seq      uint64 // keeps timers with the same due time in FIFO order
+	index    int    // position in the scheduler's heap, -1 if not in it
+}
+
+// A scheduler is a priority queue of timers, ordered by due time. Adding and
+// removing timers is O(log n), finding the next one to fire is O(1).
+type scheduler struct {
+	heap timerHeap
+	seq  uint64
+}
+
+// add schedules a timer at a specific time, it must not already be pending.
+func (s *scheduler) add(t *Timer, due int) {
+	s.seq++
+	t.due, t.seq = due, s.seq
+	heap.Push(&s.heap, t)
+}
+
+// remove drops a timer, if it is still pending.
+func (s *scheduler) remove(t *Timer) {
+	if t.index >= 0 && t.index < len(s.heap) && s.heap[t.index] == t {
+		heap.Remove(&s.heap, t.index)
+	}
+}
+
+// next returns the due time of the first pending timer, else -1.
+func (s *scheduler) next() int {
+	if len(s.heap) == 0 {
+		return -1
+	}
+	return s.heap[0].due
+}
+
+// pop removes and returns the first pending timer.
+func (s *scheduler) pop() *Timer {
+	return heap.Pop(&s.heap).(*Timer)
+}
+
+// timerHeap implements heap.Interface for a slice of timers.
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].due != h[j].due {
+		return h[i].due < h[j].due
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	t := old[n]
+	old[n] = nil
+	t.index = -1
+	*h = old[:n]
+	return t
+}
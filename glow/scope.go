@@ -0,0 +1,102 @@
+package glow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Scope determines which circuits can see a send or receive name.
+type Scope int
+
+const (
+	// Global names are shared by all circuits inside the same top-level
+	// circuit, as in Pd. Separate top-level circuits, e.g. those created by
+	// separate calls to NewCircuit, never see each other's global names.
+	// This is the default.
+	Global Scope = iota
+	// Local names are only visible inside the circuit itself.
+	Local
+	// Upward names are sent to the circuit and to all the circuits it is
+	// nested in, but received only in the circuit itself.
+	Upward
+)
+
+var scopeNames = []string{"global", "local", "up"}
+
+// String returns the name of a scope.
+func (s Scope) String() string {
+	if s < 0 || int(s) >= len(scopeNames) {
+		return fmt.Sprintf("Scope(%d)", int(s))
+	}
+	return scopeNames[s]
+}
+
+// ParseScope returns the scope with the given name.
+func ParseScope(s string) (Scope, error) {
+	for i, name := range scopeNames {
+		if s == name {
+			return Scope(i), nil
+		}
+	}
+	return Global, fmt.Errorf("unknown scope: %q", s)
+}
+
+// lastCircuitId is used to give each circuit a unique id, for use as "$0".
+var lastCircuitId int32 = 1000
+
+// Expand replaces each "$0" in a name by the id of this circuit, as in Pd.
+// This makes names such as "$0-foo" unique for every circuit instance. The
+// subpatches of a design share its id, as they do in Pd.
+func (c *Circuit) Expand(name string) string {
+	return strings.Replace(name, "$0", strconv.Itoa(c.id), -1)
+}
+
+// Send notifies all receivers of a name within the given scope.
+func (c *Circuit) Send(name string, scope Scope, m Message) {
+	topic := "msg/" + c.Expand(name)
+	switch scope {
+	case Global:
+		c.Root().Notify(topic, m...)
+	case Local:
+		c.Notify(topic, m...)
+	case Upward:
+		for x := c; x != nil; x = x.parent {
+			x.Notify(topic, m...)
+		}
+	}
+}
+
+// Receive subscribes to a name within the given scope. The returned function
+// cancels the subscription again.
+func (c *Circuit) Receive(name string, scope Scope, f func(Message)) func() {
+	topic := "msg/" + c.Expand(name)
+	if scope != Global {
+		l := c.On(topic, f)
+		return func() { c.Off(l) }
+	}
+	r := c.Root()
+	l := r.On(topic, f)
+	r.globals = append(r.globals, l)
+	return func() {
+		r := c.Root() // may have changed, if c was added to another circuit
+		r.Off(l)
+		for i, x := range r.globals {
+			if x == l {
+				r.globals = append(r.globals[:i:i], r.globals[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// moveGlobals transfers all global subscriptions of a top-level circuit to
+// another one, since the circuit is about to become nested inside it.
+func (c *Circuit) moveGlobals(dst *Circuit) {
+	for _, l := range c.globals {
+		c.Off(l)
+		dst.add(l)
+	}
+	dst.globals = append(dst.globals, c.globals...)
+	c.globals = nil
+}
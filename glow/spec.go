@@ -0,0 +1,59 @@
+package glow
+
+import "sort"
+
+// A Spec describes a type of gadget: how to create it, and how to use it.
+// This information is used by tools, e.g. to show a palette in an editor.
+type Spec struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Doc      string   `json:"doc,omitempty"`
+	Args     []Param  `json:"args,omitempty"`
+	Inlets   []Param  `json:"inlets,omitempty"`
+	Outlets  []Param  `json:"outlets,omitempty"`
+	Variadic bool     `json:"variadic,omitempty"` // if the args change the inlets or outlets
+
+	New func(args Message) Gadgetry `json:"-"`
+}
+
+// A Param describes an argument, inlet, or outlet of a gadget. The type is
+// one of "bang", "int", "float", "number", "bool", "string", "list", "map",
+// or "any".
+type Param struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Doc  string `json:"doc,omitempty"`
+	Cold bool   `json:"cold,omitempty"` // only used for inlets
+}
+
+// specs has the descriptions of all gadget types added with Register, under
+// their names as well as their aliases.
+var specs = map[string]*Spec{}
+
+// Register adds a gadget type to the Registry, under its name and aliases.
+func Register(s Spec) {
+	for _, name := range append([]string{s.Name}, s.Aliases...) {
+		specs[name] = &s
+		Registry[name] = s.New
+	}
+}
+
+// LookupSpec returns the description of a gadget type, by name or alias.
+func LookupSpec(name string) *Spec {
+	return specs[name]
+}
+
+// Specs returns descriptions of all the gadget types, sorted by name. Types
+// which were put in the Registry without using Register only have a name.
+func Specs() []Spec {
+	var v []Spec
+	for name := range Registry {
+		if s := specs[name]; s == nil {
+			v = append(v, Spec{Name: name, New: Registry[name]})
+		} else if s.Name == name {
+			v = append(v, *s)
+		}
+	}
+	sort.Slice(v, func(i, j int) bool { return v[i].Name < v[j].Name })
+	return v
+}
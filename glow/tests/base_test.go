@@ -2,6 +2,7 @@ package tests
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/jeelabs/jet/glow"
@@ -69,8 +70,8 @@ func TestPassGadgetExists(t *testing.T) {
 func TestPassAndPrintGadget(t *testing.T) {
 	tmp := glow.Debug
 	defer func() { glow.Debug = tmp }()
-	var b bytes.Buffer
-	glow.Debug = &b
+	b := &bytes.Buffer{}
+	glow.Debug = b
 
 	g1 := glow.LookupGadget("pass")
 	g2 := glow.LookupGadget("print")
@@ -199,7 +200,7 @@ func TestSendGadget(t *testing.T) {
 	c.AddWire(0, 0, 1, 0)
 
 	var reply glow.Message
-	c.On("msg:abc", func(m glow.Message) { reply = m })
+	c.On("msg/abc", func(m glow.Message) { reply = m })
 
 	c.Feed(0, glow.Message{1, 2, 3})
 
@@ -229,6 +230,26 @@ func TestSendReceiveGadgets(t *testing.T) {
 	}
 }
 
+func TestReceiveWildcard(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("r", "sensors/#"))
+	c.Add(glow.LookupGadget("print"))
+	c.AddWire(0, 0, 1, 0)
+
+	c.Notify("msg/sensors/temp", 21)
+	c.Notify("msg/sensors/hum/in", 45)
+	c.Notify("msg/actuators/fan", 1)
+
+	if b.String() != "21\n45\n" {
+		t.Errorf("expected 2 lines, got: %q", b)
+	}
+}
+
 func TestMetroGadget(t *testing.T) {
 	tmp := glow.Debug
 	defer func() { glow.Debug = tmp }()
@@ -240,8 +261,7 @@ func TestMetroGadget(t *testing.T) {
 	c.Add(glow.LookupGadget("print"))
 	c.AddWire(0, 0, 1, 0)
 
-	defer glow.Stop() // TODO manually stop all timers
-	glow.Run(500)
+	c.Run(500)
 
 	if b.String() != "[]\n[]\n[]\n[]\n" {
 		t.Error("expected '[]\n[]\n[]\n[]\n', got:", b)
@@ -320,3 +340,121 @@ func TestMosesGadget(t *testing.T) {
 		t.Errorf("expected '1 4, 2 5, 2 6', got: %q", b)
 	}
 }
+
+func TestChangeAnyMessage(t *testing.T) {
+	c, log := newProbe("change")
+	feed(c, 0, glow.Message{}, 0, glow.Message{}, 0, glow.Message{"a"},
+		0, glow.Message{"a"}, 0, glow.Message{"b"}, 0, glow.Message{0})
+	expectLog(t, log, "[0:[] 0:a 0:b 0:0]")
+	feed(c, 0, glow.Message{1, glow.Message{2, "x"}}, 0, glow.Message{1, glow.Message{2, "x"}},
+		0, glow.Message{1, glow.Message{2, "y"}}, 0, glow.Message{1.0, glow.Message{2, "y"}})
+	expectLog(t, log, "[0:1 [2 x] 0:1 [2 y]]")
+}
+
+func TestMosesAnyMessage(t *testing.T) {
+	c, log := newProbe("moses", "m")
+	feed(c, 0, glow.Message{"a"}, 0, glow.Message{"z"}, 0, glow.Message{"m"},
+		0, glow.Message{99}, 0, glow.Message{}, 0, glow.Message{"m", 1})
+	expectLog(t, log, "[0:a 1:z 1:m 0:99 0:[] 1:m 1]")
+	feed(c, 1, glow.Message{2.5}, 0, glow.Message{2}, 0, glow.Message{3})
+	expectLog(t, log, "[0:2 1:3]")
+}
+
+func TestWiringErrors(t *testing.T) {
+	c := glow.NewCircuit()
+	if err := c.Add(nil); err == nil {
+		t.Error("expected error when adding nil")
+	}
+	c.Add(glow.LookupGadget("pass"))
+	c.Add(glow.LookupGadget("print"))
+
+	err := c.AddWire(0, 0, 2, 0)
+	if err == nil || err.Error() != "cannot wire 0/0 to 2/0: no gadget 2" {
+		t.Error("expected error for bad gadget index, got:", err)
+	}
+	if err := c.AddWire(0, 1, 1, 0); err == nil {
+		t.Error("expected error for bad outlet index")
+	}
+	if err := c.AddWire(0, 0, 1, 1); err == nil {
+		t.Error("expected error for bad inlet index")
+	}
+	if err := c.AddWire(1, 0, 0, 0); err == nil {
+		t.Error("expected error, print has no outlets")
+	}
+	if err := c.AddWire(0, 0, 1, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEmitToMissingOutlet(t *testing.T) {
+	g := glow.NewGadget()
+	g.AddOutlets(1)
+	g.Emit(1, glow.Message{1}) // ignored
+	g.Emit(-1, glow.Message{1})
+}
+
+func TestFeedAndConnectErrors(t *testing.T) {
+	g := glow.LookupGadget("pass")
+	if err := g.Feed(1, nil); err == nil {
+		t.Error("expected error for bad inlet index")
+	}
+	if err := g.Feed(-1, nil); err == nil {
+		t.Error("expected error for negative inlet index")
+	}
+	if err := g.Connect(0, nil, 0); err == nil {
+		t.Error("expected error for nil destination")
+	}
+}
+
+func TestColdInletsFedFirst(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("swap", 0))
+	c.Add(glow.LookupGadget("print", "left"))
+	c.Add(glow.LookupGadget("print", "right"))
+	c.AddWire(0, 0, 1, 0) // the hot inlet is connected first
+	c.AddWire(0, 0, 1, 1)
+	c.AddWire(1, 0, 2, 0)
+	c.AddWire(1, 1, 3, 0)
+
+	c.Feed(0, glow.Message{5})
+
+	if b.String() != "right 5\nleft 5\n" {
+		t.Errorf("expected 2 lines, got: %q", b)
+	}
+	swap := c.Gadgets()[1].(*glow.Gadget)
+	if swap.IsCold(0) || !swap.IsCold(1) || swap.IsCold(2) {
+		t.Error("expected only inlet 1 to be cold")
+	}
+}
+
+func TestFanOutRightToLeft(t *testing.T) {
+	var v []string
+	g := glow.NewGadget()
+	for _, s := range []string{"a", "b", "c"} {
+		s := s
+		g.AddInlet(func(m glow.Message) { v = append(v, s+m.String()) })
+	}
+	g.AddColdInlet(func(m glow.Message) { v = append(v, "d"+m.String()) })
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(g)
+	c.Add(glow.LookupGadget("pass"))
+	for _, i := range []int{0, 2, 1, 3} {
+		c.AddWire(0, 0, 1, i)
+	}
+	c.AddWire(0, 0, 2, 0)
+	c.AddWire(2, 0, 1, 0) // depth-first: handled before the next fan-out
+
+	c.Feed(0, glow.Message{1})
+
+	if fmt.Sprint(v) != "[d1 c1 b1 a1 a1]" {
+		t.Error("expected '[d1 c1 b1 a1 a1]', got:", fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/jeelabs/jet/glow/broker"
+)
+
+// newBroker starts an embedded broker on a free local port.
+func newBroker(t *testing.T) *broker.Broker {
+	t.Helper()
+	b, err := broker.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// newClient connects to a broker, with an optional last will on "will".
+func newClient(t *testing.T, b *broker.Broker, id string, will bool) mqtt.Client {
+	t.Helper()
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(b.URL())
+	opts.SetClientID(id)
+	if will {
+		opts.SetWill("will", id, 1, false)
+	}
+	c := mqtt.NewClient(opts)
+	if tok := c.Connect(); tok.Wait() && tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	return c
+}
+
+// subscribe returns a channel with "topic=payload" for each incoming message.
+func subscribe(t *testing.T, c mqtt.Client, filter string, qos byte) chan string {
+	t.Helper()
+	ch := make(chan string, 10)
+	tok := c.Subscribe(filter, qos, func(_ mqtt.Client, m mqtt.Message) {
+		ch <- m.Topic() + "=" + string(m.Payload())
+	})
+	if tok.Wait() && tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	return ch
+}
+
+// expectRecv checks that the next message on a channel is as expected.
+func expectRecv(t *testing.T, ch chan string, expect string) {
+	t.Helper()
+	select {
+	case s := <-ch:
+		if s != expect {
+			t.Errorf("expected %s, got: %s", expect, s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected %s, got nothing", expect)
+	}
+}
+
+func publish(c mqtt.Client, topic string, qos byte, retain bool, payload string) {
+	c.Publish(topic, qos, retain, payload).Wait()
+}
+
+func TestBrokerMatch(t *testing.T) {
+	for _, e := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"+/b", "a/b", true},
+		{"#", "$SYS/x", false},
+		{"$SYS/#", "$SYS/x", true},
+		{"a/b", "a/b/c", false},
+	} {
+		if broker.Match(e.filter, e.topic) != e.match {
+			t.Errorf("%s %s: expected %v", e.filter, e.topic, e.match)
+		}
+	}
+}
+
+func TestBrokerPubSub(t *testing.T) {
+	b := newBroker(t)
+	defer b.Close()
+	c := newClient(t, b, "c1", false)
+	defer c.Disconnect(0)
+
+	ch := subscribe(t, c, "a/+", 1)
+	publish(c, "a/b", 0, false, "hello")
+	publish(c, "x/b", 1, false, "nope")
+	publish(c, "a/c", 1, false, "world")
+	expectRecv(t, ch, "a/b=hello")
+	expectRecv(t, ch, "a/c=world")
+}
+
+func TestBrokerRetained(t *testing.T) {
+	b := newBroker(t)
+	defer b.Close()
+	c := newClient(t, b, "c1", false)
+	defer c.Disconnect(0)
+
+	publish(c, "r/1", 1, true, "one")
+	publish(c, "r/2", 1, true, "two")
+	publish(c, "r/2", 1, true, "") // clears it
+
+	ch := subscribe(t, c, "r/#", 0)
+	expectRecv(t, ch, "r/1=one")
+	select {
+	case s := <-ch:
+		t.Errorf("unexpected message: %s", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBrokerWill(t *testing.T) {
+	b := newBroker(t)
+	defer b.Close()
+	c1 := newClient(t, b, "c1", false)
+	defer c1.Disconnect(0)
+	ch := subscribe(t, c1, "will", 0)
+
+	c2 := newClient(t, b, "c2", true)
+	c2.Disconnect(250) // a clean disconnect discards the will
+	c3 := newClient(t, b, "c3", true)
+	newClient(t, b, "c3", false).Disconnect(0) // takes over, drops c3
+	expectRecv(t, ch, "will=c3")
+	c3.Disconnect(0)
+}
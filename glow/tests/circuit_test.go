@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestGadgetInfo(t *testing.T) {
+	g := glow.LookupGadget("swap", 123)
+	if g.Name() != "swap" {
+		t.Error("expected swap, got:", g.Name())
+	}
+	if g.Args().String() != "123" {
+		t.Error("expected 123, got:", g.Args())
+	}
+	g.Args()[0] = 456 // only changes a copy
+	if g.Args().String() != "123" {
+		t.Error("args have been modified:", g.Args())
+	}
+	if g.NumInlets() != 2 || g.NumOutlets() != 2 {
+		t.Error("expected 2 inlets and 2 outlets, got:",
+			g.NumInlets(), g.NumOutlets())
+	}
+	g = glow.NewGadget()
+	if g.Name() != "" || !g.Args().IsBang() {
+		t.Error("expected no name and no args, got:", g.Name(), g.Args())
+	}
+}
+
+func TestCircuitGadgets(t *testing.T) {
+	c := glow.NewCircuitFromText(subPatch).(*glow.Circuit)
+	v := []string{}
+	for _, g := range c.Gadgets() {
+		v = append(v, fmt.Sprintf("%s(%s)%d/%d",
+			g.Name(), g.Args(), g.NumInlets(), g.NumOutlets()))
+	}
+	s := fmt.Sprint(v)
+	if s != "[inlet([])0/1 text(comment with , comma and ; semicolon on two lines)0/0 pd(sub)1/2 print(1)1/0 print(2)1/0]" {
+		t.Error("unexpected gadgets:", s)
+	}
+	sub := c.Gadgets()[2].(*glow.Circuit)
+	if len(sub.Gadgets()) != 4 {
+		t.Error("expected 4 gadgets in sub-circuit, got:", len(sub.Gadgets()))
+	}
+}
+
+func TestCircuitWires(t *testing.T) {
+	c := glow.NewCircuitFromText(subPatch).(*glow.Circuit)
+	s := fmt.Sprint(c.Wires())
+	if s != "[{0 0 2 0} {2 0 3 0} {2 1 4 0}]" {
+		t.Error("unexpected wires:", s)
+	}
+	sub := c.Gadgets()[2].(*glow.Circuit)
+	s = fmt.Sprint(sub.Wires())
+	if s != "[{0 0 1 0} {1 0 2 0} {1 1 3 0}]" {
+		t.Error("unexpected wires in sub-circuit:", s)
+	}
+	if w := glow.NewCircuit().Wires(); len(w) != 0 {
+		t.Error("expected no wires, got:", w)
+	}
+}
+
+func TestRemoveGadget(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("pass"))
+	c.Add(glow.LookupGadget("print", 1))
+	c.Add(glow.LookupGadget("print", 2))
+	c.AddWire(0, 0, 1, 0)
+	c.AddWire(1, 0, 2, 0)
+	c.AddWire(0, 0, 3, 0)
+
+	if err := c.RemoveGadget(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Feed(0, glow.Message{"a"})
+	if b.String() != "2 a\n" {
+		t.Errorf("expected '2 a', got: %q", b)
+	}
+
+	if c.Gadgets()[1] != nil || len(c.Gadgets()) != 4 {
+		t.Error("expected an empty slot at index 1")
+	}
+	if s := fmt.Sprint(c.Wires()); s != "[{0 0 3 0}]" {
+		t.Error("unexpected wires:", s)
+	}
+	if err := c.RemoveGadget(1); err == nil {
+		t.Error("expected error when removing twice")
+	}
+	if err := c.AddWire(0, 0, 1, 0); err == nil {
+		t.Error("expected error when wiring to a removed gadget")
+	}
+
+	c.Add(glow.LookupGadget("print", 5))
+	if err := c.AddWire(0, 0, 4, 0); err != nil {
+		t.Error(err)
+	}
+	b.Reset()
+	c.Feed(0, glow.Message{"b"})
+	if b.String() != "2 b\n5 b\n" {
+		t.Errorf("expected 2 lines, got: %q", b)
+	}
+}
+
+func TestRemovedGadgetCleansUp(t *testing.T) {
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("metro", 100))
+	c.Add(glow.LookupGadget("r", "abc"))
+	c.Add(glow.LookupGadget("r", "abc"))
+
+	var reply glow.Message
+	c.On("msg/abc", func(m glow.Message) { reply = m })
+
+	if c.NextTimer() < 0 {
+		t.Fatal("expected a pending timer")
+	}
+	c.RemoveGadget(0)
+	if c.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+
+	removed := false
+	c.Gadgets()[1].(*glow.Gadget).OnRemoved = func(*glow.Circuit) {
+		removed = true
+	}
+	c.RemoveGadget(1)
+	if !removed {
+		t.Error("OnRemoved was not called")
+	}
+	c.Notify("msg/abc", 1)
+	if reply.String() != "1" {
+		t.Error("expected 1, got:", reply)
+	}
+}
+
+func TestRemoveSubCircuit(t *testing.T) {
+	sub := glow.NewCircuit()
+	sub.Add(glow.LookupGadget("metro", 100))
+	c := glow.NewCircuit()
+	c.Add(sub)
+
+	if c.NextTimer() < 0 {
+		t.Fatal("expected a pending timer")
+	}
+	c.RemoveGadget(0)
+	if c.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+}
+
+func TestRemoveWire(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("print", 1))
+	c.Add(glow.LookupGadget("print", 2))
+	c.AddWire(0, 0, 1, 0)
+	c.AddWire(0, 0, 2, 0)
+
+	if err := c.RemoveWire(0, 0, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveWire(0, 0, 1, 0); err == nil {
+		t.Error("expected error when removing a wire twice")
+	}
+	if err := c.RemoveWire(0, 0, 7, 0); err == nil {
+		t.Error("expected error for bad gadget index")
+	}
+
+	c.Feed(0, glow.Message{"c"})
+	if b.String() != "2 c\n" {
+		t.Errorf("expected '2 c', got: %q", b)
+	}
+}
+
+func TestReplaceGadget(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("swap", 1))
+	c.Add(glow.LookupGadget("print", "a"))
+	c.Add(glow.LookupGadget("print", "b"))
+	c.AddWire(0, 0, 1, 0)
+	c.AddWire(1, 0, 2, 0)
+	c.AddWire(1, 1, 3, 0)
+
+	if err := c.Replace(1, glow.LookupGadget("pass")); err != nil {
+		t.Fatal(err)
+	}
+	if c.Gadgets()[1].Name() != "pass" {
+		t.Error("expected pass, got:", c.Gadgets()[1].Name())
+	}
+	if s := fmt.Sprint(c.Wires()); s != "[{0 0 1 0} {1 0 2 0}]" {
+		t.Error("unexpected wires:", s)
+	}
+	c.Feed(0, glow.Message{"x"})
+	if b.String() != "a x\n" {
+		t.Errorf("expected 'a x', got: %q", b)
+	}
+
+	if err := c.Replace(9, glow.LookupGadget("pass")); err == nil {
+		t.Error("expected error for bad gadget index")
+	}
+	if err := c.Replace(1, nil); err == nil {
+		t.Error("expected error for nil gadget")
+	}
+}
+
+func TestWriteTextAfterRemove(t *testing.T) {
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("pass"))
+	c.Add(glow.LookupGadget("print"))
+	c.AddWire(0, 0, 1, 0)
+	c.AddWire(0, 0, 2, 0)
+	c.RemoveGadget(1)
+
+	var b bytes.Buffer
+	c.WriteText(&b)
+	expect := `#N canvas 0 50 450 300 10;
+#X obj 20 20 inlet;
+#X obj 20 60 print;
+#X connect 0 0 1 0;
+`
+	if b.String() != expect {
+		t.Errorf("expected:\n%s\ngot:\n%s", expect, b.String())
+	}
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestSimulatedRunUntil(t *testing.T) {
+	c := glow.NewCircuit()
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	c.SetPeriodic(10, func() { count++ })
+	c.SetTimer(1005, cancel)
+
+	start := time.Now()
+	c.RunUntil(ctx)
+
+	if c.Now() != 1005 {
+		t.Error("expected 1005, got:", c.Now())
+	}
+	if count != 100 {
+		t.Error("expected 100, got:", count)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Error("simulated time should be fast, was:", elapsed)
+	}
+}
+
+func TestRealTimeRun(t *testing.T) {
+	c := glow.NewCircuit()
+	c.SetClock(glow.NewRealClock())
+
+	fired := -1
+	c.SetTimer(30, func() { fired = c.Now() })
+
+	start := time.Now()
+	c.Run(50)
+	elapsed := time.Since(start)
+
+	if fired != 30 {
+		t.Error("expected timer at 30, got:", fired)
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Error("real time should take at least 50 ms, was:", elapsed)
+	}
+}
+
+func TestRealTimeMetro(t *testing.T) {
+	count := 0
+	c := glow.NewCircuit()
+	c.SetClock(glow.NewRealClock())
+	c.Add(glow.LookupGadget("metro", 20))
+	c.Add(newCounter(&count))
+	c.AddWire(0, 0, 1, 0)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 110*time.Millisecond)
+	defer cancel()
+	c.RunUntil(ctx)
+
+	if count < 3 || count > 6 {
+		t.Error("expected about 5 ticks, got:", count)
+	}
+}
+
+func TestRealTimeWakeup(t *testing.T) {
+	c := glow.NewCircuit()
+	c.SetClock(glow.NewRealClock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.SetTimer(10, cancel) // set while RunUntil is waiting
+	}()
+
+	start := time.Now()
+	c.RunUntil(ctx)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Error("new timer did not wake up the run loop, took:", elapsed)
+	}
+}
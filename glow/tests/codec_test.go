@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	"github.com/jeelabs/jet/glow/codec"
+)
+
+var codecMessages = []glow.Message{
+	{},
+	{0},
+	{123, "abc", nil, -45},
+	nestedMessage,
+	{1.5, 2.0, -0.25, 1e300, math.MaxInt64, math.MinInt64},
+	{true, false, "", "\x00\u00e9\n"},
+	{glow.Message{}, glow.Message{glow.Message{1}, glow.Message{}}},
+	{map[string]interface{}{
+		"a": 1, "b": glow.Message{2.5, "c"}, "": nil,
+		"d": map[string]interface{}{"e": true},
+	}},
+}
+
+func testRoundTrip(t *testing.T, c codec.Codec) {
+	for _, m := range codecMessages {
+		data, err := c.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m2, err := c.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: %v", m, err)
+		}
+		if !reflect.DeepEqual(m, m2) {
+			t.Errorf("round trip failed, expected %#v, got: %#v", m, m2)
+		}
+	}
+}
+
+func TestJsonRoundTrip(t *testing.T) {
+	testRoundTrip(t, codec.JSON)
+}
+
+func TestCborRoundTrip(t *testing.T) {
+	testRoundTrip(t, codec.CBOR)
+}
+
+func TestCodecRegistry(t *testing.T) {
+	if codec.Registry["json"] != codec.JSON || codec.Registry["cbor"] != codec.CBOR {
+		t.Error("expected json and cbor entries in registry")
+	}
+}
+
+func TestJsonMarshal(t *testing.T) {
+	m := glow.Message{1, 2.0, "a\"b", nil, glow.Message{},
+		map[string]interface{}{"y": false, "x": 0.5}}
+	data, err := codec.JSON.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `[1,2.0,"a\"b",null,[],{"x":0.5,"y":false}]` {
+		t.Errorf("wrong JSON, got: %s", data)
+	}
+}
+
+func TestJsonUnmarshalScalar(t *testing.T) {
+	m, err := codec.JSON.Unmarshal([]byte(` {"id": 5, "t": 21.5} `))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsMap() || !m.Get("id").IsInt() || !m.Get("t").IsFloat() {
+		t.Errorf("expected map with int and float, got: %#v", m)
+	}
+	m, err = codec.JSON.Unmarshal([]byte(`"hi"`))
+	if err != nil || m.AsString() != "hi" {
+		t.Errorf("expected hi, got: %v %v", m, err)
+	}
+}
+
+func TestJsonErrors(t *testing.T) {
+	if _, err := codec.JSON.Marshal(glow.Message{math.NaN()}); err == nil {
+		t.Error("expected error for NaN")
+	}
+	if _, err := codec.JSON.Marshal(glow.Message{int64(1)}); err == nil {
+		t.Error("expected error for int64")
+	}
+	if _, err := codec.JSON.Unmarshal([]byte(`[1,2`)); err == nil {
+		t.Error("expected error for truncated input")
+	}
+	if _, err := codec.JSON.Unmarshal([]byte(`[1] [2]`)); err == nil {
+		t.Error("expected error for trailing data")
+	}
+}
+
+func TestCborMarshal(t *testing.T) {
+	// expected values are from the examples in RFC 7049, appendix A
+	for _, x := range []struct {
+		v interface{}
+		s string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{1000000000000, "1b000000e8d4a51000"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{true, "f5"},
+		{nil, "f6"},
+		{"IETF", "6449455446"},
+		{glow.Message{1, glow.Message{2, 3}}, "8201820203"},
+		{map[string]interface{}{"a": 1, "b": glow.Message{2, 3}}, "a26161016162820203"},
+	} {
+		data, err := codec.CBOR.Marshal(glow.Message{x.v})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := fmt.Sprintf("%x", data); s != "81"+x.s {
+			t.Errorf("encoding %v: expected 81%s, got: %s", x.v, x.s, s)
+		}
+	}
+}
+
+func TestCborUnmarshalScalar(t *testing.T) {
+	m, err := codec.CBOR.Unmarshal([]byte{0xFA, 0x47, 0xC3, 0x50, 0x00})
+	if err != nil || !m.IsFloat() || m.AsFloat() != 100000.0 {
+		t.Errorf("expected float 100000, got: %v %v", m, err)
+	}
+}
+
+func TestCborErrors(t *testing.T) {
+	if _, err := codec.CBOR.Marshal(glow.Message{uint(1)}); err == nil {
+		t.Error("expected error for uint")
+	}
+	if _, err := codec.CBOR.Unmarshal([]byte{0x83, 0x01, 0x02}); err == nil {
+		t.Error("expected error for truncated input")
+	}
+	if _, err := codec.CBOR.Unmarshal([]byte{0x01, 0x02}); err == nil {
+		t.Error("expected error for trailing data")
+	}
+	if _, err := codec.CBOR.Unmarshal([]byte{0x9F, 0xFF}); err == nil {
+		t.Error("expected error for indefinite length")
+	}
+}
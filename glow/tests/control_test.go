@@ -0,0 +1,247 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestBangGadget(t *testing.T) {
+	c, log := newProbe("b")
+	feed(c, 0, glow.Message{1}, 0, glow.Message{"a", 2})
+	expectLog(t, log, "[0:[] 0:[]]")
+}
+
+func TestTriggerGadget(t *testing.T) {
+	c, log := newProbe("t", "b", "f", "s", "l", 7)
+	feed(c, 0, glow.Message{3})
+	expectLog(t, log, `[4:7 3:3 2:"" 1:3 0:[]]`)
+	feed(c, 0, glow.Message{"x", 1})
+	expectLog(t, log, "[4:7 3:x 1 2:x 1:0 0:[]]")
+
+	c, log = newProbe("trigger")
+	feed(c, 0, glow.Message{})
+	expectLog(t, log, "[1:0 0:0]")
+}
+
+func TestFloatAndIntGadgets(t *testing.T) {
+	c, log := newProbe("f", 1.5)
+	feed(c, 0, glow.Message{}, 1, glow.Message{2}, 0, glow.Message{},
+		0, glow.Message{3.25})
+	expectLog(t, log, "[0:1.5 0:2 0:3.25]")
+
+	c, log = newProbe("i")
+	feed(c, 0, glow.Message{}, 0, glow.Message{3.75}, 1, glow.Message{-2.5},
+		0, glow.Message{})
+	expectLog(t, log, "[0:0 0:3 0:-2]")
+}
+
+func TestSelectGadget(t *testing.T) {
+	c, log := newProbe("sel", 1, "a", 3)
+	feed(c, 0, glow.Message{3.0}, 0, glow.Message{"a"}, 0, glow.Message{2},
+		0, glow.Message{1, 2})
+	expectLog(t, log, "[2:[] 1:[] 3:2 3:1 2]")
+
+	c, log = newProbe("select", 5)
+	feed(c, 0, glow.Message{5}, 1, glow.Message{6}, 0, glow.Message{5},
+		0, glow.Message{6})
+	expectLog(t, log, "[0:[] 1:5 0:[]]")
+
+	c, log = newProbe("sel", 3, true)
+	feed(c, 0, glow.Message{"3"}, 0, glow.Message{true}, 0, glow.Message{1})
+	expectLog(t, log, `[2:"3" 1:[] 2:1]`)
+}
+
+func TestRouteGadget(t *testing.T) {
+	c, log := newProbe("route", "a", 2, "bang")
+	feed(c, 0, glow.Message{"a", 1, 2}, 0, glow.Message{2, "x"},
+		0, glow.Message{}, 0, glow.Message{"b", 3})
+	expectLog(t, log, "[0:1 2 1:x 2:[] 3:b 3]")
+
+	c, log = newProbe("route", "float", "symbol", "list")
+	feed(c, 0, glow.Message{1}, 0, glow.Message{"s"}, 0, glow.Message{1, 2})
+	expectLog(t, log, "[0:1 1:s 2:1 2]")
+}
+
+func TestPackGadget(t *testing.T) {
+	c, log := newProbe("pack", "f", "s", 9)
+	feed(c, 0, glow.Message{}, 1, glow.Message{"x"}, 2, glow.Message{8},
+		0, glow.Message{1}, 0, glow.Message{2, "y"})
+	expectLog(t, log, `[0:0 "" 9 0:1 x 8 0:2 y 8]`)
+	if c.Gadgets()[0].NumInlets() != 3 {
+		t.Error("expected 3 inlets")
+	}
+}
+
+func TestUnpackGadget(t *testing.T) {
+	c, log := newProbe("unpack", "f", "f", "f")
+	feed(c, 0, glow.Message{1, "a", 3, 4}, 0, glow.Message{5, 6})
+	expectLog(t, log, "[2:3 1:a 0:1 1:6 0:5]")
+}
+
+func TestSpigotGadget(t *testing.T) {
+	c, log := newProbe("spigot")
+	feed(c, 0, glow.Message{1}, 1, glow.Message{1}, 0, glow.Message{2},
+		1, glow.Message{0}, 0, glow.Message{3})
+	expectLog(t, log, "[0:2]")
+}
+
+func TestGateGadget(t *testing.T) {
+	c, log := newProbe("gate", 2)
+	feed(c, 1, glow.Message{1}, 0, glow.Message{2}, 1, glow.Message{2},
+		0, glow.Message{1}, 1, glow.Message{3}, 0, glow.Message{3},
+		1, glow.Message{4})
+	expectLog(t, log, "[1:2 0:3]")
+}
+
+func TestCounterGadget(t *testing.T) {
+	c, log := newProbe("counter", 1, 3)
+	for i := 0; i < 4; i++ {
+		feed(c, 0, glow.Message{})
+	}
+	feed(c, 1, glow.Message{}, 0, glow.Message{}, 0, glow.Message{3},
+		0, glow.Message{})
+	expectLog(t, log, "[0:1 0:2 1:[] 0:3 0:1 0:1 1:[] 0:3 0:1]")
+}
+
+func TestDelayGadget(t *testing.T) {
+	c, log := newProbe("del", 100)
+	feed(c, 0, glow.Message{})
+	c.Run(50)
+	feed(c, 0, glow.Message{}) // restarts the delay
+	c.Run(99)
+	expectLog(t, log, "[]")
+	c.Run(1)
+	expectLog(t, log, "[0:[]]")
+
+	feed(c, 0, glow.Message{20}, 1, glow.Message{10})
+	c.Run(15)
+	feed(c, 0, glow.Message{"stop"})
+	c.Run(100)
+	expectLog(t, log, "[]")
+	feed(c, 0, glow.Message{})
+	c.Run(10)
+	expectLog(t, log, "[0:[]]")
+}
+
+func TestPipeGadget(t *testing.T) {
+	c, log := newProbe("pipe", 100)
+	feed(c, 0, glow.Message{1})
+	c.Run(30)
+	feed(c, 0, glow.Message{2})
+	c.Run(90)
+	expectLog(t, log, "[0:1]")
+	c.Run(10)
+	expectLog(t, log, "[0:2]")
+
+	feed(c, 0, glow.Message{3}, 0, glow.Message{}, 0, glow.Message{"flush"})
+	expectLog(t, log, "[0:3 0:3]")
+	feed(c, 0, glow.Message{4}, 0, glow.Message{"clear"})
+	c.Run(200)
+	expectLog(t, log, "[]")
+	if c.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+}
+
+func TestLineGadget(t *testing.T) {
+	c, log := newProbe("line", 0, 25)
+	feed(c, 0, glow.Message{10, 100})
+	c.Run(200)
+	expectLog(t, log, "[0:0.0 0:2.5 0:5.0 0:7.5 0:10.0]")
+
+	feed(c, 1, glow.Message{50}, 0, glow.Message{0})
+	c.Run(100)
+	feed(c, 0, glow.Message{4})
+	expectLog(t, log, "[0:10.0 0:5.0 0:0.0 0:4.0]")
+	if c.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+}
+
+func TestRandomGadget(t *testing.T) {
+	c, log := newProbe("random", 5)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		feed(c, 0, glow.Message{})
+	}
+	for _, s := range *log {
+		seen[s] = true
+	}
+	if len(seen) != 5 || !seen["0:0"] || !seen["0:4"] {
+		t.Error("expected 5 different values, got:", seen)
+	}
+
+	*log = (*log)[:0]
+	feed(c, 0, glow.Message{"seed", 123}, 0, glow.Message{})
+	first := fmt.Sprint(*log)
+	*log = (*log)[:0]
+	feed(c, 0, glow.Message{"seed", 123}, 0, glow.Message{})
+	expectLog(t, log, first)
+}
+
+func TestUntilGadget(t *testing.T) {
+	c, log := newProbe("until")
+	feed(c, 0, glow.Message{3})
+	expectLog(t, log, "[0:[] 0:[] 0:[]]")
+
+	// stop an endless loop with a counter, wired back to the right inlet
+	c.Add(glow.LookupGadget("counter", 1, 5))
+	c.Add(glow.LookupGadget("sel", 5))
+	c.AddWire(0, 0, 2, 0)
+	c.AddWire(2, 0, 3, 0)
+	c.AddWire(3, 0, 0, 1)
+	feed(c, 0, glow.Message{})
+	expectLog(t, log, "[0:[] 0:[] 0:[] 0:[] 0:[]]")
+}
+
+func TestArithmeticGadgets(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  glow.Message
+		right interface{}
+		out   string
+	}{
+		{"+", glow.Message{2}, 3, "5"},
+		{"+", glow.Message{2.5}, 3, "5.5"},
+		{"-", glow.Message{2}, 3, "-1"},
+		{"*", glow.Message{2}, 0.5, "1.0"},
+		{"/", glow.Message{6}, 3, "2"},
+		{"/", glow.Message{7}, 2, "3.5"},
+		{"/", glow.Message{7}, 0, "0"},
+		{"max", glow.Message{7}, 9, "9"},
+		{"min", glow.Message{7}, 9, "7"},
+		{"pow", glow.Message{2}, 10, "1024"},
+		{"pow", glow.Message{-2}, 0.5, "0.0"},
+		{"mod", glow.Message{-7}, 3, "2"},
+		{"div", glow.Message{-7}, 3, "-3"},
+		{"%", glow.Message{-7}, 3, "-1"},
+		{"%", glow.Message{7}, 0, "0"},
+		{"==", glow.Message{3}, 3.0, "1"},
+		{"!=", glow.Message{3}, 3, "0"},
+		{">", glow.Message{3.5}, 3, "1"},
+		{"<", glow.Message{3}, 3, "0"},
+		{">=", glow.Message{3}, 3, "1"},
+		{"<=", glow.Message{4}, 3, "0"},
+		{"&&", glow.Message{1}, 0, "0"},
+		{"||", glow.Message{1}, 0, "1"},
+		{"+", glow.Message{1, 10}, 3, "11"}, // a list sets both operands
+	}
+	for _, x := range tests {
+		c, log := newProbe(x.name, x.right)
+		feed(c, 0, x.left)
+		if s := fmt.Sprint(*log); s != "[0:"+x.out+"]" {
+			t.Errorf("%v %s %v: expected %s, got: %s",
+				x.left, x.name, x.right, x.out, s)
+		}
+	}
+}
+
+func TestOperatorInlets(t *testing.T) {
+	c, log := newProbe("-")
+	feed(c, 0, glow.Message{5}, 1, glow.Message{2}, 0, glow.Message{},
+		0, glow.Message{10})
+	expectLog(t, log, "[0:5 0:3 0:8]")
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestLookupDesign(t *testing.T) {
+	tmp, path := glow.Debug, glow.DesignPath
+	defer func() { glow.Debug, glow.DesignPath = tmp, path }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+	glow.DesignPath = []string{"nowhere", "testdata"}
+
+	g := glow.LookupGadget("tag", "a", 5)
+	if _, ok := g.(*glow.Circuit); !ok {
+		t.Fatal("expected a circuit, got:", g)
+	}
+	g.Feed(0, glow.Message{"hi"})
+
+	if b.String() != "a-0 5 hi\n" {
+		t.Errorf("expected 1 line, got: %q", b)
+	}
+	if g.Name() != "tag" || g.Args().String() != "a 5" {
+		t.Error("unexpected name and args:", g.Name(), g.Args())
+	}
+	if glow.LookupGadget("nosuchdesign") != nil {
+		t.Error("expected nil for a missing design")
+	}
+}
+
+func TestDesignInDesign(t *testing.T) {
+	tmp, path := glow.Debug, glow.DesignPath
+	defer func() { glow.Debug, glow.DesignPath = tmp, path }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+	glow.DesignPath = []string{"testdata"}
+
+	text := "#N canvas 0 50 450 300 10;\n" +
+		"#X obj 10 10 inlet;\n" +
+		"#X obj 10 40 tag x y z;\n" +
+		"#X connect 0 0 1 0;\n"
+	c, err := glow.ParseCircuit(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Feed(0, glow.Message{1})
+	c.Feed(0, glow.Message{2})
+
+	if b.String() != "x-z y 1\nx-z y 2\n" {
+		t.Errorf("expected 2 lines, got: %q", b)
+	}
+
+	// the design is written back by name, not as a subpatch
+	b.Reset()
+	if err := c.WriteText(b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), " tag x y z;\n") {
+		t.Errorf("expected the design by name, got: %q", b)
+	}
+}
+
+func TestRecursiveDesign(t *testing.T) {
+	tmp, path := glow.Debug, glow.DesignPath
+	defer func() { glow.Debug, glow.DesignPath = tmp, path }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+	glow.DesignPath = []string{"testdata"}
+
+	glow.LookupGadget("self")
+
+	if !strings.Contains(b.String(), "designs nested too deeply") {
+		t.Errorf("expected a nesting error, got: %q", b)
+	}
+}
+
+func TestNestedDesignErrors(t *testing.T) {
+	tmp, path := glow.Debug, glow.DesignPath
+	defer func() { glow.Debug, glow.DesignPath = tmp, path }()
+	glow.Debug = &bytes.Buffer{}
+	glow.DesignPath = []string{"testdata"}
+
+	_, err := glow.ParseCircuit("#N canvas 0 50 450 300 10;\n" +
+		"#X obj 10 10 broken;\n")
+	expect := "line 2: broken: testdata/broken.pd: line 3: unknown gadget: nosuchgadget"
+	if err == nil || err.Error() != expect {
+		t.Errorf("expected %q, got: %v", expect, err)
+	}
+
+	_, err = glow.ParseCircuit("#N canvas 0 50 450 300 10;\n" +
+		"#X obj 10 10 self;\n")
+	if err == nil || !strings.Contains(err.Error(), "designs nested too deeply") {
+		t.Errorf("expected a nesting error, got: %v", err)
+	}
+}
+
+func TestConcurrentDesigns(t *testing.T) {
+	tmp, path := glow.Debug, glow.DesignPath
+	defer func() { glow.Debug, glow.DesignPath = tmp, path }()
+	glow.Debug = ioutil.Discard
+	glow.DesignPath = []string{"testdata"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				glow.LookupGadget("self")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := glow.ParseCircuit("#N canvas 0 50 450 300 10;\n" +
+			"#X obj 10 10 tag a b;\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
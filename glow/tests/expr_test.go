@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestExprGadget(t *testing.T) {
+	tests := []struct {
+		expr string
+		in   glow.Message
+		out  string
+	}{
+		{"$f1 * 2", glow.Message{3}, "6.0"},
+		{"$i1 / 2", glow.Message{7.9}, "3"},
+		{"$f1 / 2", glow.Message{7}, "3.5"},
+		{"1 + 2 * 3 - 4", glow.Message{}, "3"},
+		{"(1 + 2) * 3", glow.Message{}, "9"},
+		{"-$i1 % 4", glow.Message{7}, "-3"},
+		{"$f1 > 2 && $f1 < 5", glow.Message{3}, "1"},
+		{"!($f1 > 2) || 0", glow.Message{3}, "0"},
+		{"$i1 & 6 | 1 << 4 ^ 1", glow.Message{5}, "21"},
+		{"~0", glow.Message{}, "-1"},
+		{"if($f1 >= 0, sqrt($f1), -1)", glow.Message{16}, "4.0"},
+		{"if($f1 >= 0, sqrt($f1), -1)", glow.Message{-16}, "-1"},
+		{"max(abs($i1), 3)", glow.Message{-5}, "5"},
+		{"pow(2, 10) + int(2.7) + floor(-0.5)", glow.Message{}, "1025.0"},
+		{"atan2(0, -1) == 4 * atan(1)", glow.Message{}, "1"},
+		{"$s1 == $s2", glow.Message{"a", "a"}, "1"},
+		{"strlen($s1)", glow.Message{"hello"}, "5"},
+		{"1.5e1 / 0", glow.Message{}, "0.0"},
+	}
+	for _, x := range tests {
+		args := []interface{}{}
+		for _, s := range strings.Fields(x.expr) {
+			args = append(args, s)
+		}
+		c, log := newProbe("expr", args...)
+		feed(c, 0, x.in)
+		if s := fmt.Sprint(*log); s != "[0:"+x.out+"]" {
+			t.Errorf("%s: expected %s, got: %s", x.expr, x.out, s)
+		}
+	}
+}
+
+func TestExprInletsAndOutlets(t *testing.T) {
+	c, log := newProbe("expr", "$f1", "+", "$i3", ";", "$f1", "*", 10)
+	g := c.Gadgets()[0]
+	if g.NumInlets() != 3 || g.NumOutlets() != 2 {
+		t.Error("expected 3 inlets and 2 outlets, got:",
+			g.NumInlets(), g.NumOutlets())
+	}
+	feed(c, 2, glow.Message{2.5}, 0, glow.Message{1}, 0, glow.Message{},
+		0, glow.Message{1.5, 0, 7})
+	expectLog(t, log, "[1:10.0 0:3.0 1:10.0 0:3.0 1:15.0 0:8.5]")
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		"1 +", "(1", "foo(1)", "sqrt(1, 2)", "$x1", "$f0", "1 2",
+		"$f1 + $i1", "1 # 2", "1 = 2",
+	} {
+		g := glow.LookupGadget("expr", expr)
+		if g.(*glow.Gadget).Err() == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestExprErrorInDesign(t *testing.T) {
+	text := "#N canvas 0 50 450 300 10;\n" +
+		"#X obj 10 10 expr \\$f1 +;\n"
+	_, err := glow.ParseCircuit(text)
+	if err == nil || err.Error() != "line 2: expr: unexpected end of expression" {
+		t.Error("unexpected error:", err)
+	}
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+// newTracer returns a gadget which logs all its lifecycle events.
+func newTracer(name string, log *[]string) *glow.Gadget {
+	g := glow.NewGadget()
+	g.OnAdded = func(*glow.Circuit) { *log = append(*log, "add "+name) }
+	g.OnStart = func() { *log = append(*log, "start "+name) }
+	g.OnStop = func() { *log = append(*log, "stop "+name) }
+	g.OnRemoved = func(*glow.Circuit) { *log = append(*log, "remove "+name) }
+	return g
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	var log []string
+	sub := glow.NewCircuit()
+	sub.Add(newTracer("a", &log))
+	sub.Add(newTracer("b", &log))
+	c := glow.NewCircuit()
+	c.Add(newTracer("c", &log))
+	c.Stop()
+	c.Add(sub) // stops sub, since c is not running
+	c.Start()
+	c.Stop()
+	c.Close()
+	c.Start() // no effect after close
+
+	s := fmt.Sprint(log)
+	if s != "[add a start a add b start b add c start c stop c"+
+		" stop b stop a start c start a start b stop b stop a stop c]" {
+		t.Error("unexpected lifecycle:", s)
+	}
+	if c.Running() || sub.Running() {
+		t.Error("closed circuits should not be running")
+	}
+}
+
+func TestParentAfterRemoval(t *testing.T) {
+	c := glow.NewCircuit()
+	g := glow.NewGadget()
+	c.Add(g)
+	if g.Parent() != c {
+		t.Error("expected the circuit as parent")
+	}
+	c.RemoveGadget(0)
+	if g.Parent() != nil {
+		t.Error("expected no parent after removal, got:", g.Parent())
+	}
+}
+
+func TestGadgetNotStartedByLookup(t *testing.T) {
+	g := glow.LookupGadget("metro", 100)
+	if g.(*glow.Gadget).Running() {
+		t.Error("gadget should not be running")
+	}
+}
+
+func TestTimersOutsideCircuit(t *testing.T) {
+	g := glow.NewGadget()
+	if g.SetTimer(10, func() { t.Error("timer fired") }) != nil || g.Now() != 0 {
+		t.Error("expected no timers and no time outside a circuit")
+	}
+
+	// timed gadgets can be used, they just never see any time passing
+	for _, e := range []struct {
+		name string
+		args []interface{}
+		msg  glow.Message
+	}{
+		{"metro", []interface{}{100}, nil},
+		{"delay", []interface{}{100}, glow.Message{}},
+		{"pipe", []interface{}{100}, glow.Message{1}},
+		{"line", nil, glow.Message{1, 100}},
+		{"debounce", []interface{}{100}, glow.Message{1}},
+		{"throttle", []interface{}{100}, glow.Message{1}},
+		{"stats", []interface{}{100, "ms"}, glow.Message{1}},
+	} {
+		g := glow.LookupGadget(e.name, e.args...)
+		g.Start()
+		if e.msg != nil {
+			g.Feed(0, e.msg)
+		}
+		g.Stop()
+	}
+}
+
+func TestStopAndResumeMetro(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("metro", 100))
+	c.Add(glow.LookupGadget("print"))
+	c.AddWire(0, 0, 1, 0)
+
+	defer c.Close()
+	c.Run(250)
+	c.Stop()
+	c.Run(250)
+	c.Start()
+	c.Run(250)
+
+	if b.String() != "[]\n[]\n[]\n[]\n" {
+		t.Errorf("expected 4 bangs, got: %q", b)
+	}
+}
+
+func TestNoTimersAfterClose(t *testing.T) {
+	sub := glow.NewCircuit()
+	sub.Add(glow.LookupGadget("metro", 100))
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("metro", 50))
+	c.Add(sub)
+	c.Run(500)
+
+	if c.NextTimer() < 0 {
+		t.Fatal("expected pending timers")
+	}
+	c.Close()
+	if c.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+}
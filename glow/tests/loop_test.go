@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+// newCounter returns a gadget which counts incoming messages, without locking.
+func newCounter(count *int) *glow.Gadget {
+	g := glow.NewGadget()
+	g.AddInlet(func(m glow.Message) { *count++ })
+	return g
+}
+
+func TestPostIsSynchronousWhenIdle(t *testing.T) {
+	var l glow.Loop
+	v := []int{}
+	l.Post(func() {
+		v = append(v, 1)
+		l.Post(func() { v = append(v, 3) }) // queued until we're done
+		v = append(v, 2)
+	})
+
+	if len(v) != 3 || v[0] != 1 || v[1] != 2 || v[2] != 3 {
+		t.Error("expected [1 2 3], got:", v)
+	}
+}
+
+func TestConcurrentPosts(t *testing.T) {
+	count := 0
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(newCounter(&count))
+	c.AddWire(0, 0, 1, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Post(func() { c.Feed(0, glow.Message{j}) })
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count != 8000 {
+		t.Error("expected 8000, got:", count)
+	}
+}
+
+func TestServeLoop(t *testing.T) {
+	count := 0
+	sub := glow.NewCircuit()
+	sub.Add(glow.LookupGadget("inlet"))
+	sub.Add(newCounter(&count))
+	sub.AddWire(0, 0, 1, 0)
+	c := glow.NewCircuit()
+	c.Add(sub)
+
+	if sub.Loop() != c.Loop() {
+		t.Fatal("sub-circuit should use the loop of its parent")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		c.Loop().Serve(ctx)
+		close(served)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				sub.Post(func() { sub.Feed(0, nil) })
+			}
+		}()
+	}
+	wg.Wait()
+
+	done := make(chan int)
+	c.Post(func() { done <- count })
+	if n := <-done; n != 2000 {
+		t.Error("expected 2000, got:", n)
+	}
+
+	cancel()
+	<-served
+}
+
+func TestTimersAndPostsConcurrently(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("metro", 10))
+	c.Add(glow.LookupGadget("print"))
+	c.AddWire(0, 0, 2, 0)
+	c.AddWire(1, 0, 2, 0)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.Run(10)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.Post(func() { c.Feed(0, glow.Message{"x"}) })
+		}
+	}()
+	wg.Wait()
+
+	if n := strings.Count(b.String(), "[]\n"); n != 100 {
+		t.Error("expected 100 bangs, got:", n)
+	}
+	if n := strings.Count(b.String(), "x\n"); n != 100 {
+		t.Error("expected 100 x's, got:", n)
+	}
+}
@@ -1,6 +1,9 @@
 package tests
 
 import (
+	"fmt"
+	"math"
+	"reflect"
 	"testing"
 
 	"github.com/jeelabs/jet/glow"
@@ -151,3 +154,202 @@ func TestNilInMessage(t *testing.T) {
 		t.Error("expected [], got:", m.String())
 	}
 }
+
+func TestFloatMessage(t *testing.T) {
+	m := glow.Message{1.5}
+	if !m.IsFloat() {
+		t.Errorf("should be float")
+	}
+	if m.IsInt() {
+		t.Errorf("should not be int")
+	}
+	if !m.IsNumber() {
+		t.Errorf("should be number")
+	}
+	if m.AsFloat() != 1.5 {
+		t.Errorf("expected 1.5, got: %g", m.AsFloat())
+	}
+}
+
+func TestNumericCoercion(t *testing.T) {
+	if x := (glow.Message{2.9}).AsInt(); x != 2 {
+		t.Errorf("expected 2, got: %d", x)
+	}
+	if x := (glow.Message{-2.9}).AsInt(); x != -2 {
+		t.Errorf("expected -2, got: %d", x)
+	}
+	if x := (glow.Message{7}).AsFloat(); x != 7 {
+		t.Errorf("expected 7, got: %g", x)
+	}
+	if x := (glow.Message{"1.5"}).AsFloat(); x != 0 {
+		t.Errorf("expected 0, got: %g", x)
+	}
+}
+
+func TestBoolMessage(t *testing.T) {
+	m := glow.Message{true}
+	if !m.IsBool() {
+		t.Errorf("should be bool")
+	}
+	if !m.AsBool() {
+		t.Errorf("should be true")
+	}
+	if (glow.Message{false}).AsBool() {
+		t.Errorf("should be false")
+	}
+	if !(glow.Message{3}).AsBool() {
+		t.Errorf("non-zero should be true")
+	}
+	if (glow.Message{"abc"}).AsBool() {
+		t.Errorf("string should be false")
+	}
+}
+
+func TestMapMessage(t *testing.T) {
+	m := glow.Message{map[string]interface{}{
+		"id": 12, "temp": 21.5, "tags": glow.Message{"a", "b"},
+	}}
+	if !m.IsMap() {
+		t.Errorf("should be map")
+	}
+	if x := m.Get("id"); !x.IsInt() || x.AsInt() != 12 {
+		t.Errorf("expected 12, got: %s", x)
+	}
+	if x := m.Get("temp").AsFloat(); x != 21.5 {
+		t.Errorf("expected 21.5, got: %g", x)
+	}
+	if x := m.Get("tags").At(1).AsString(); x != "b" {
+		t.Errorf("expected \"b\", got: %s", x)
+	}
+	if x := m.Get("blah"); !x.IsBang() {
+		t.Errorf("expected bang, got: %s", x)
+	}
+	if x := (glow.Message{1}).Get("id"); !x.IsBang() {
+		t.Errorf("expected bang, got: %s", x)
+	}
+}
+
+func TestNewTypesAsString(t *testing.T) {
+	m := glow.Message{1.5, 2.0, true, "false", "3.5", "[x]",
+		map[string]interface{}{"b": glow.Message{1, 2}, "a": "x y"}}
+	s := m.String()
+	if s != `1.5 2.0 true "false" "3.5" "[x]" {a "x y" b [1 2]}` {
+		t.Errorf("wrong string, got: %s", s)
+	}
+}
+
+func TestParseAsMessage(t *testing.T) {
+	m := glow.ParseAsMessage("obj 10 20 print 1.5 true")
+	if fmt.Sprintf("%#v", m) !=
+		`glow.Message{"obj", 10, 20, "print", 1.5, true}` {
+		t.Errorf("wrong parse, got: %#v", m)
+	}
+	if m := glow.ParseAsMessage("[]"); !m.IsBang() {
+		t.Errorf("expected bang, got: %#v", m)
+	}
+	if m := glow.ParseAsMessage("inf nan 1e 0x10"); m.String() != "inf nan 1e 0x10" {
+		t.Errorf("expected only strings, got: %#v", m)
+	}
+	if m := glow.ParseAsMessage(""); !reflect.DeepEqual(m, glow.Message{""}) {
+		t.Errorf("expected an empty string, got: %#v", m)
+	}
+}
+
+func TestInfAndNaNRoundTrip(t *testing.T) {
+	m := glow.Message{math.Inf(1), math.Inf(-1), math.NaN(), "NaN", "+Inf"}
+	s := m.String()
+	m2 := glow.ParseAsMessage(s)
+	if len(m2) != 5 || m2.At(0).AsFloat() != math.Inf(1) ||
+		m2.At(1).AsFloat() != math.Inf(-1) || !math.IsNaN(m2.At(2).AsFloat()) ||
+		m2.At(3).AsString() != "NaN" || m2.At(4).AsString() != "+Inf" {
+		t.Errorf("round trip failed for %s, got: %#v", s, m2)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	for _, m := range []glow.Message{
+		nestedMessage,
+		{1.5, -2.0, 1e21, true, false, "true", "1.5", "", "a]b"},
+		{map[string]interface{}{"x": 1, "y y": 2.5, "z": glow.Message{true, nil}}},
+		{"a", map[string]interface{}{}, glow.Message{map[string]interface{}{"k": "v"}, 3}},
+	} {
+		s := m.String()
+		m2 := glow.ParseAsMessage(s)
+		if !reflect.DeepEqual(m, m2) {
+			t.Errorf("round trip failed for %s, got: %#v", s, m2)
+		}
+	}
+}
+
+func TestMessageEqual(t *testing.T) {
+	for _, e := range []struct {
+		a, b  glow.Message
+		equal bool
+	}{
+		{nil, glow.Message{}, true},
+		{glow.Message{1}, glow.Message{1.0}, true},
+		{glow.Message{1}, glow.Message{"1"}, false},
+		{glow.Message{"a", 2}, glow.Message{"a", 2}, true},
+		{glow.Message{"a", 2}, glow.Message{"a"}, false},
+		{nestedMessage, glow.Message{123, "abc", glow.Message{4, nil, 6}, "d e", 789, "f\ng"}, true},
+		{nestedMessage, glow.Message{123, "abc", glow.Message{4, nil, 7}, "d e", 789, "f\ng"}, false},
+		{glow.Message{glow.Message{1, glow.Message{"x"}}}, glow.Message{glow.Message{1, glow.Message{"x"}}}, true},
+		{glow.Message{glow.Message{1, glow.Message{"x"}}}, glow.Message{glow.Message{1, glow.Message{"y"}}}, false},
+		{glow.Message{map[string]interface{}{"k": 1}}, glow.Message{map[string]interface{}{"k": 1.0}}, true},
+		{glow.Message{map[string]interface{}{"k": 1}}, glow.Message{map[string]interface{}{"j": 1}}, false},
+		{glow.Message{nil}, glow.Message{}, false},
+		{glow.Message{glow.Message{}, 1}, glow.ParseAsMessage("[] 1"), true},
+		{glow.Message{glow.Message{}}, glow.Message{glow.Message{nil}}, false},
+		{glow.Message{[]byte("a")}, glow.Message{[]byte("a")}, true},
+		{glow.Message{[]byte("a")}, glow.Message{[]byte("b")}, false},
+		{glow.Message{int64(1)}, glow.Message{[]interface{}{1}}, false},
+	} {
+		if e.a.Equal(e.b) != e.equal || e.b.Equal(e.a) != e.equal {
+			t.Errorf("%s == %s: expected %v", e.a, e.b, e.equal)
+		}
+	}
+}
+
+func TestMessageCompare(t *testing.T) {
+	sorted := []glow.Message{
+		{},
+		{nil},
+		{nil, 1},
+		{glow.Message{}, 2},
+		{false},
+		{true},
+		{-1},
+		{0.5},
+		{1},
+		{1, 2},
+		{2},
+		{""},
+		{"a"},
+		{"a", 1},
+		{"b"},
+		{glow.Message{1}},
+		{glow.Message{1, "x"}},
+		{glow.Message{2}},
+		{map[string]interface{}{}},
+		{map[string]interface{}{"a": 1}},
+		{map[string]interface{}{"a": 2}},
+		{map[string]interface{}{"b": 0}},
+		{[]byte{1}},
+		{[]byte{2}},
+		{int64(3)},
+		{int64(5)},
+	}
+	for i, a := range sorted {
+		for j, b := range sorted {
+			expect := 0
+			if i < j {
+				expect = -1
+			} else if i > j {
+				expect = 1
+			}
+			if c := a.Compare(b); c != expect {
+				t.Errorf("compare %s with %s: expected %d, got %d", a, b, expect, c)
+			}
+		}
+	}
+}
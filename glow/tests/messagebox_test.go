@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestMessageGadget(t *testing.T) {
+	c, log := newProbe("msg", "a", "$2", "$1-x", ",", 5)
+	feed(c, 0, glow.Message{}, 0, glow.Message{"p", 2.5})
+	expectLog(t, log, "[0:a 0 0-x 0:5 0:a 2.5 p-x 0:5]")
+
+	feed(c, 0, glow.Message{"set", 1, "$1"}, 0, glow.Message{7})
+	expectLog(t, log, "[0:1 7]")
+}
+
+func TestMessageGadgetSends(t *testing.T) {
+	c, log := newProbe("message", 1, ";", "foo", 2, "$1", ",", 3, ";", "bar")
+	var got []string
+	c.On("msg/foo", func(m glow.Message) { got = append(got, "foo:"+m.String()) })
+	c.On("msg/bar", func(m glow.Message) { got = append(got, "bar:"+m.String()) })
+
+	feed(c, 0, glow.Message{9})
+	expectLog(t, log, "[0:1]")
+	if fmt.Sprint(got) != "[foo:2 9 foo:3 bar:[]]" {
+		t.Error("expected '[foo:2 9 foo:3 bar:[]]', got:", fmt.Sprint(got))
+	}
+}
+
+func TestMessageGadgetSendsOutsideCircuit(t *testing.T) {
+	c := glow.NewCircuit()
+	g := glow.LookupGadget("msg", 1, ";", "foo", 2)
+	c.Add(g)
+	var got []string
+	c.On("msg/foo", func(m glow.Message) { got = append(got, m.String()) })
+
+	g.Feed(0, glow.Message{})
+	c.RemoveGadget(0)
+	g.Feed(0, glow.Message{})
+	if fmt.Sprint(got) != "[2]" {
+		t.Error("expected '[2]', got:", fmt.Sprint(got))
+	}
+}
+
+func TestMessageBoxFromText(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	text := "#N canvas 0 50 450 300 10;\n" +
+		"#X obj 10 10 inlet;\n" +
+		"#X msg 10 40 \\$1 \\, 2 \\; foo 3, f 10;\n" +
+		"#X obj 10 70 print;\n" +
+		"#X obj 90 70 r foo;\n" +
+		"#X obj 90 100 print foo;\n" +
+		"#X connect 0 0 1 0;\n" +
+		"#X connect 1 0 2 0;\n" +
+		"#X connect 3 0 4 0;\n"
+	c, err := glow.ParseCircuit(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Feed(0, glow.Message{1})
+
+	if b.String() != "1\n2\nfoo 3\n" {
+		t.Errorf("expected 3 lines, got: %q", b)
+	}
+	b.Reset()
+	c.WriteText(b)
+	if !bytes.Contains(b.Bytes(), []byte(` \$1 \, 2 \; foo 3;`)) {
+		t.Errorf("message box not written back as is: %q", b)
+	}
+}
+
+func TestMessageBoxSelectors(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	text := "#N canvas 0 50 450 300 10;\n" +
+		"#X obj 10 10 inlet;\n" +
+		"#X msg 10 40 bang;\n" +
+		"#X obj 10 70 t a;\n" +
+		"#X obj 10 100 f 42;\n" +
+		"#X obj 10 130 print;\n" +
+		"#X msg 90 40 float 3;\n" +
+		"#X msg 170 40 list a b;\n" +
+		"#X connect 0 0 1 0;\n" +
+		"#X connect 1 0 2 0;\n" +
+		"#X connect 2 0 3 0;\n" +
+		"#X connect 3 0 4 0;\n" +
+		"#X connect 0 0 5 0;\n" +
+		"#X connect 5 0 4 0;\n" +
+		"#X connect 0 0 6 0;\n" +
+		"#X connect 6 0 4 0;\n"
+	c, err := glow.ParseCircuit(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Feed(0, glow.Message{})
+
+	if b.String() != "42\n3\na b\n" {
+		t.Errorf("expected 3 lines, got: %q", b)
+	}
+}
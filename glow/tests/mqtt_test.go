@@ -1,8 +1,7 @@
 package tests
 
 import (
-	"bytes"
-	"os"
+	"fmt"
 	"testing"
 	"time"
 
@@ -10,26 +9,149 @@ import (
 	_ "github.com/jeelabs/jet/glow/gadgets"
 )
 
-const SKIP = true // skip this test by default, it depends on my local setup
-
 func TestMqttConnect(t *testing.T) {
-	if SKIP || testing.Short() || os.Getenv("USER") != "jcw" {
-		t.SkipNow()
-	} else {
-		tmp := glow.Debug
-		defer func() { glow.Debug = tmp }()
-		b := &bytes.Buffer{}
-		glow.Debug = b
-
-		c := glow.NewCircuit()
-		c.Add(glow.LookupGadget("mqtt", "hub/1hz", "tcp://mohse:1883"))
-		c.Add(glow.LookupGadget("print"))
-		c.AddWire(0, 0, 1, 0)
-
-		time.Sleep(3 * time.Second)
-
-		if b.String() == "" {
-			t.Error("no messages received")
+	b := newBroker(t)
+	defer b.Close()
+
+	ch := make(chan glow.Message, 10)
+	c := glow.NewCircuit()
+	defer c.Post(c.Close)
+	c.Post(func() {
+		c.Add(glow.LookupGadget("mqtt-sub", "t/#", b.URL(), "json"))
+		c.Add(glow.LookupGadget("mqtt-pub", "t/x", b.URL(), "json"))
+		r := glow.NewGadget()
+		r.AddInlet(func(m glow.Message) { ch <- m })
+		c.Add(r)
+		c.AddWire(0, 0, 2, 0)
+		c.AddWire(0, 1, 2, 0)
+	})
+
+	for m := range ch {
+		if m.String() == "connected" {
+			break
+		}
+	}
+	c.Post(func() {
+		c.Gadgets()[1].Feed(0, glow.Message{1, "a", 2.5})
+	})
+	select {
+	case m := <-ch:
+		if s := m.String(); s != "t/x [1 a 2.5]" {
+			t.Errorf("unexpected message: %s", s)
 		}
+	case <-time.After(3 * time.Second):
+		t.Error("no messages received")
+	}
+}
+
+// stateProbe puts an mqtt gadget in a new circuit, and returns a channel with
+// the messages arriving on its state outlet. Since the gadget emits from other
+// goroutines, all access to the circuit has to go through its event loop.
+func stateProbe(name string, o int, args ...interface{}) (*glow.Circuit, chan glow.Message) {
+	ch := make(chan glow.Message, 10)
+	c := glow.NewCircuit()
+	c.Post(func() {
+		c.Add(glow.LookupGadget(name, args...))
+		r := glow.NewGadget()
+		r.AddInlet(func(m glow.Message) { ch <- m })
+		c.Add(r)
+		c.AddWire(0, o, 1, 0)
+	})
+	return c, ch
+}
+
+func TestMqttNoBroker(t *testing.T) {
+	c, ch := stateProbe("mqtt-sub", 1, "#", "tcp://127.0.0.1:1")
+	defer c.Post(c.Close)
+	if m := <-ch; m.String() != "disconnected" {
+		t.Errorf("expected initial state, got: %s", m)
+	}
+	select {
+	case m := <-ch:
+		if m.At(0).AsString() != "disconnected" || len(m) != 2 {
+			t.Errorf("expected disconnected with reason, got: %s", m)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("no connection failure reported")
+	}
+}
+
+func TestMqttPublishWhileDisconnected(t *testing.T) {
+	c, ch := stateProbe("mqtt-pub", 0, "a/b", "tcp://127.0.0.1:1?will=a/b&payload=gone")
+	defer c.Post(c.Close)
+	<-ch
+	c.Post(func() {
+		c.Gadgets()[0].Feed(0, glow.Message{"hello"}) // dropped, no panic
+	})
+}
+
+func TestMqttSharedPattern(t *testing.T) {
+	b := newBroker(t)
+	defer b.Close()
+
+	// expectQos waits for one client to have the given QoS for a filter
+	expectQos := func(filter string, qos byte) {
+		t.Helper()
+		var m map[string]byte
+		for i := 0; i < 100; i++ {
+			if m = b.Subscribers(filter); len(m) == 1 {
+				for _, q := range m {
+					if q == qos {
+						return
+					}
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s: expected QoS %d, got: %v", filter, qos, m)
+	}
+
+	ch := make(chan string, 10)
+	c := glow.NewCircuit()
+	defer c.Post(c.Close)
+	addSub := func(i int, pattern string, qos int) {
+		c.Add(glow.LookupGadget("mqtt-sub", pattern, b.URL(), "", qos))
+		r := glow.NewGadget()
+		r.AddInlet(func(m glow.Message) { ch <- fmt.Sprint(i, " ", m) })
+		c.Add(r)
+		c.AddWire(2*i, 0, 2*i+1, 0)
+		c.AddWire(2*i, 1, 2*i+1, 0)
+	}
+	c.Post(func() { addSub(0, "t/#", 1) })
+	for s := <-ch; s != "0 connected"; s = <-ch {
+	}
+	expectQos("t/#", 1)
+
+	// a user with a lower QoS doesn't downgrade the shared subscription, and
+	// the subscription after it shows that the broker has seen everything
+	c.Post(func() {
+		addSub(1, "t/#", 0)
+		addSub(2, "s/#", 0)
+	})
+	expectQos("s/#", 0)
+	expectQos("t/#", 1)
+
+	// once the user with the higher QoS is gone, the subscription is
+	// downgraded, and only the remaining user gets messages
+	c.Post(func() { c.RemoveGadget(0) })
+	expectQos("t/#", 0)
+	for len(ch) > 0 {
+		<-ch // skip the state messages
+	}
+	p := newClient(t, b, "pub", false)
+	defer p.Disconnect(0)
+	publish(p, "t/x", 1, false, "hi")
+	expectRecv(t, ch, "1 t/x hi")
+	select {
+	case s := <-ch:
+		t.Error("unexpected message:", s)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestMqttBadBroker(t *testing.T) {
+	g := glow.LookupGadget("mqtt-pub", "a", "tcp://%zz")
+	if g.(*glow.Gadget).Err() == nil {
+		t.Error("expected an error for a malformed broker URL")
 	}
 }
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 	"time"
@@ -107,14 +108,53 @@ func TestNotificationOff(t *testing.T) {
 	}
 }
 
+func TestWildcardNotifications(t *testing.T) {
+	v := []string{}
+	nf := glow.MakeNotifier()
+	nf.On("a/b/c", func(glow.Message) { v = append(v, "abc") })
+	nf.On("a/+/c", func(glow.Message) { v = append(v, "a+c") })
+	nf.On("a/#", func(glow.Message) { v = append(v, "a#") })
+	nf.On("+/b", func(glow.Message) { v = append(v, "+b") })
+	nf.On("#", func(glow.Message) { v = append(v, "#") })
+
+	for _, s := range []string{"a/b/c", "a/x/c", "a", "x/b", "a/b/c/d", "b"} {
+		v = append(v, s+":")
+		nf.Notify(s)
+	}
+
+	expect := "[a/b/c: # a# abc a+c a/x/c: # a# a+c a: # a# x/b: # +b" +
+		" a/b/c/d: # a# b: #]"
+	if fmt.Sprint(v) != expect {
+		t.Error("unexpected matches:", fmt.Sprint(v))
+	}
+}
+
+func TestWildcardOff(t *testing.T) {
+	calls := 0
+	nf := glow.MakeNotifier()
+	l1 := nf.On("a/+", func(glow.Message) { calls += 1 })
+	l2 := nf.On("a/#", func(glow.Message) { calls += 10 })
+	nf.On("a/b", func(glow.Message) { calls += 100 })
+
+	nf.Off(l1)
+	nf.Notify("a/b")
+	nf.Off(l2)
+	nf.Off(l2) // unsubscribing twice is harmless
+	nf.Notify("a/b")
+
+	if calls != 210 {
+		t.Error("expected 210, got:", calls)
+	}
+}
+
 func TestRunning(t *testing.T) {
-	glow.Now = 0
+	c := glow.NewCircuit()
 	now := time.Now()
-	glow.Run(10)
+	c.Run(10)
 	elapsed := time.Since(now)
 
-	if glow.Now != 10 {
-		t.Error("expected 10, got:", glow.Now)
+	if c.Now() != 10 {
+		t.Error("expected 10, got:", c.Now())
 	}
 	if elapsed > time.Millisecond {
 		t.Error("simulated time should be instant, was:", elapsed)
@@ -122,26 +162,27 @@ func TestRunning(t *testing.T) {
 }
 
 func TestNoNextTimer(t *testing.T) {
-	if glow.NextTimer >= 0 {
+	c := glow.NewCircuit()
+	if c.NextTimer() >= 0 {
 		t.Error("there should be no timeouts pending")
 	}
 }
 
 func TestMultipleTimers(t *testing.T) {
-	glow.Now = 0
+	c := glow.NewCircuit()
 	t1, t2, t3 := -1, -1, -1
-	glow.SetTimer(123, func() { t1 = glow.Now })
-	glow.SetTimer(789, func() { t2 = glow.Now })
-	glow.SetTimer(456, func() { t3 = glow.Now })
+	c.SetTimer(123, func() { t1 = c.Now() })
+	c.SetTimer(789, func() { t2 = c.Now() })
+	c.SetTimer(456, func() { t3 = c.Now() })
 
-	if glow.NextTimer != 123 {
-		t.Error("expected", 123, "got:", glow.NextTimer)
+	if c.NextTimer() != 123 {
+		t.Error("expected", 123, "got:", c.NextTimer())
 	}
 
-	glow.Run(1000)
+	c.Run(1000)
 
-	if glow.Now != 1000 {
-		t.Error("expected 1000, got:", glow.Now)
+	if c.Now() != 1000 {
+		t.Error("expected 1000, got:", c.Now())
 	}
 
 	if t1 != 123 {
@@ -154,42 +195,150 @@ func TestMultipleTimers(t *testing.T) {
 		t.Error("expected", 456, "got:", t3)
 	}
 
-	if glow.NextTimer >= 0 {
+	if c.NextTimer() >= 0 {
 		t.Error("there should be no timeouts pending")
 	}
 }
 
 func TestCancelledNextTimer(t *testing.T) {
-	glow.Now = 0
-	l := glow.SetTimer(123, func() {})
+	c := glow.NewCircuit()
+	l := c.SetTimer(123, func() {})
 
-	if glow.NextTimer != 123 {
-		t.Error("expected", 123, "got:", glow.NextTimer)
+	if c.NextTimer() != 123 {
+		t.Error("expected", 123, "got:", c.NextTimer())
 	}
 
-	glow.CancelTimer(l)
+	c.CancelTimer(l)
 
-	if glow.NextTimer >= 0 {
+	if c.NextTimer() >= 0 {
 		t.Error("there should be no timeouts pending")
 	}
 }
 
 func TestPeriodicimer(t *testing.T) {
-	glow.Now = 0
+	c := glow.NewCircuit()
 	v := []int{}
-	glow.SetPeriodic(123, func() { v = append(v, glow.Now) })
+	c.SetPeriodic(123, func() { v = append(v, c.Now()) })
 
-	if glow.NextTimer != 123 {
-		t.Error("expected", 123, "got:", glow.NextTimer)
+	if c.NextTimer() != 123 {
+		t.Error("expected", 123, "got:", c.NextTimer())
 	}
 
-	defer glow.Stop() // TODO manually stop all timers
-	glow.Run(500)
+	c.Run(500)
 
 	if fmt.Sprint(v) != "[123 246 369 492]" {
 		t.Error("expected '[123 246 369 492]', got:", fmt.Sprint(v))
 	}
-	if glow.NextTimer != 615 {
-		t.Error("expected 615, got:", glow.NextTimer)
+	if c.NextTimer() != 615 {
+		t.Error("expected 615, got:", c.NextTimer())
+	}
+}
+
+func TestNotificationOffOneOfMany(t *testing.T) {
+	calls := 0
+	nf := glow.MakeNotifier()
+	l := nf.On("ping", func(glow.Message) { calls += 1 })
+	nf.On("ping", func(glow.Message) { calls += 10 })
+
+	nf.Off(l)
+	nf.Notify("ping")
+
+	if calls != 10 {
+		t.Error("expected 10, got:", calls)
+	}
+}
+
+func TestCancelPeriodicTimer(t *testing.T) {
+	c := glow.NewCircuit()
+	v := []int{}
+	tm := c.SetPeriodic(100, func() { v = append(v, c.Now()) })
+	c.Run(250)
+	c.CancelTimer(tm)
+	c.Run(250)
+
+	if fmt.Sprint(v) != "[100 200]" {
+		t.Error("expected '[100 200]', got:", fmt.Sprint(v))
+	}
+	if c.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+}
+
+func TestIndependentCircuitTimers(t *testing.T) {
+	c1, c2 := glow.NewCircuit(), glow.NewCircuit()
+	v1, v2 := []int{}, []int{}
+	c1.SetPeriodic(100, func() { v1 = append(v1, c1.Now()) })
+	c2.SetPeriodic(150, func() { v2 = append(v2, c2.Now()) })
+	c1.Run(300)
+	c2.Run(100)
+
+	if fmt.Sprint(v1) != "[100 200 300]" {
+		t.Error("expected '[100 200 300]', got:", fmt.Sprint(v1))
+	}
+	if fmt.Sprint(v2) != "[]" {
+		t.Error("expected '[]', got:", fmt.Sprint(v2))
+	}
+	if c1.Now() != 300 || c2.Now() != 100 {
+		t.Error("expected 300 and 100, got:", c1.Now(), c2.Now())
+	}
+}
+
+func TestStopOneOfTwoCircuits(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c1, c2 := glow.NewCircuit(), glow.NewCircuit()
+	for _, c := range []*glow.Circuit{c1, c2} {
+		c.Add(glow.LookupGadget("metro", 100))
+		c.Add(glow.LookupGadget("print"))
+		c.AddWire(0, 0, 1, 0)
+	}
+	c1.Stop()
+	c1.Run(500)
+	c2.Run(500)
+
+	if c1.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+	if b.String() != "[]\n[]\n[]\n[]\n[]\n" {
+		t.Errorf("expected 5 bangs, got: %q", b)
+	}
+}
+
+func TestSubCircuitTimersMove(t *testing.T) {
+	sub := glow.NewCircuit()
+	sub.Run(1000)
+	fired := -1
+	sub.SetTimer(50, func() { fired = sub.Now() })
+
+	c := glow.NewCircuit()
+	c.Run(10)
+	c.Add(sub)
+
+	if sub.NextTimer() != 60 {
+		t.Error("expected 60, got:", sub.NextTimer())
+	}
+	c.Run(100)
+	if fired != 60 {
+		t.Error("expected 60, got:", fired)
+	}
+}
+
+func TestParallelCircuits(t *testing.T) {
+	for i := 1; i <= 4; i++ {
+		ms := 10 * i
+		t.Run(fmt.Sprint(ms), func(t *testing.T) {
+			t.Parallel()
+			c := glow.NewCircuit()
+			count := 0
+			c.SetPeriodic(ms, func() { count++ })
+			c.Run(1000)
+
+			if count != 1000/ms {
+				t.Error("expected", 1000/ms, "got:", count)
+			}
+		})
 	}
 }
@@ -0,0 +1,244 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+// a patch with a subpatch, a comment, and a record wrapped across two lines
+var subPatch = `#N canvas 480 220 450 300 10;
+#X obj 30 20 inlet;
+#X text 120 20 comment with \, comma and \; semicolon
+on two lines;
+#N canvas 0 22 450 300 sub 0;
+#X obj 30 20 inlet;
+#X obj 30 60 swap 7;
+#X obj 30 100 outlet;
+#X obj 90 100 outlet;
+#X connect 0 0 1 0;
+#X connect 1 0 2 0;
+#X connect 1 1 3 0;
+#X restore 30 60 pd sub;
+#X obj 30 100 print 1;
+#X obj 90 100 print
+2, f 8;
+#X connect 0 0 2 0;
+#X connect 2 0 3 0;
+#X connect 2 1 4 0;
+`
+
+func TestSubPatch(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuitFromText(subPatch)
+	c.Feed(0, glow.Message{11})
+
+	if b.String() != "2 11\n1 7\n" {
+		t.Errorf("expected 2 lines, got: %q", b)
+	}
+}
+
+var boxesPatch = `#N canvas 0 22 450 300 10;
+#X obj 30 20 inlet;
+#X floatatom 30 50 5 0 0 0 - - -;
+#X msg 30 80 1.5 \$1 done;
+#X obj 30 110 print a;
+#X obj 90 110 print b;
+#X connect 0 0 1 0;
+#X connect 1 0 2 0;
+#X connect 2 0 3 0;
+#X connect 1 0 4 0;
+`
+
+func TestMessageAndAtomBoxes(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuitFromText(boxesPatch)
+	c.Feed(0, glow.Message{3})
+	c.Feed(0, glow.Message{})
+
+	if b.String() != "a 1.5 3 done\nb 3\na 1.5 3 done\nb 3\n" {
+		t.Errorf("expected 4 lines, got: %q", b)
+	}
+}
+
+func TestEscapedNumberIsString(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuitFromText(`#N canvas 0 0 450 300 10;
+#X obj 10 10 inlet;
+#X obj 10 40 print \12 \ a;
+#X connect 0 0 1 0;`)
+	c.Feed(0, glow.Message{"x"})
+
+	if b.String() != "\"12\" \" a\" x\n" {
+		t.Errorf("expected quoted args, got: %q", b)
+	}
+}
+
+func TestParseCircuit(t *testing.T) {
+	c, err := glow.ParseCircuit(swapPatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil {
+		t.Error("expected a circuit")
+	}
+}
+
+var badPatch = `#N canvas 0 22 450 300 10;
+#X obj 30 20 inlet;
+#X obj 30 50 blah 1 2;
+#X obj 30;
+#X obj 30 80 print;
+#X connect 0 0 3 0;
+#X connect 0 1 3 0;
+#X connect 0 0 9 0;
+#X connect 0 0 x 0;
+#X connect 0 0 1 0;
+oops;
+#N canvas 0 22 450 300 sub 0;
+`
+
+func TestParseCircuitErrors(t *testing.T) {
+	c, err := glow.ParseCircuit(badPatch)
+	if c != nil {
+		t.Error("expected no circuit")
+	}
+	errs, ok := err.(glow.DesignError)
+	if !ok {
+		t.Fatalf("expected a design error, got: %T", err)
+	}
+	expect := []string{
+		"line 3: unknown gadget: blah",
+		"line 4: malformed record: #X obj 30",
+		"line 7: cannot wire 0/1 to 3/0: no outlet 1",
+		"line 8: cannot wire 0/0 to 9/0: no gadget 9",
+		"line 9: malformed record: #X connect 0 0 x 0",
+		"line 10: cannot wire 0/0 to 1/0: no inlet 0",
+		"line 11: malformed record: oops",
+		"line 12: canvas without restore",
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("expected %d errors, got:\n%v", len(expect), err)
+	}
+	for i, e := range expect {
+		if errs[i].Error() != e {
+			t.Errorf("expected %q, got: %q", e, errs[i])
+		}
+	}
+
+	_, err = glow.ParseCircuit("#N canvas 0 0 1 1 10;\n" +
+		"#N canvas 0 0 1 1 sub 0;\n#X restore 1 2;")
+	if err == nil || err.Error() != "line 3: malformed record: #X restore 1 2" {
+		t.Errorf("expected a malformed restore, got: %v", err)
+	}
+}
+
+func TestLenientLoader(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuitFromText(badPatch)
+	if b.Len() == 0 {
+		t.Error("expected errors on debug output")
+	}
+
+	b.Reset()
+	c.Feed(0, glow.Message{"ok"})
+	if b.String() != "ok\n" {
+		t.Errorf("expected 'ok', got: %q", b)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("swap", "a b", "12", 1.5))
+	c.Add(glow.LookupGadget("print", 1))
+	c.Add(glow.LookupGadget("print", "$0-x;"))
+	c.AddWire(0, 0, 1, 0)
+	c.AddWire(1, 0, 2, 0)
+	c.AddWire(1, 1, 3, 0)
+
+	var b bytes.Buffer
+	if err := c.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	expect := `#N canvas 0 50 450 300 10;
+#X obj 20 20 inlet;
+#X obj 20 60 swap a\ b \12 1.5;
+#X obj 20 100 print 1;
+#X obj 140 100 print \$0-x\;;
+#X connect 0 0 1 0;
+#X connect 1 0 2 0;
+#X connect 1 1 3 0;
+`
+	if b.String() != expect {
+		t.Errorf("expected:\n%s\ngot:\n%s", expect, b.String())
+	}
+}
+
+func TestWriteTextRoundTrip(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	out := &bytes.Buffer{}
+	glow.Debug = out
+
+	for _, patch := range []string{subPatch, boxesPatch} {
+		c, err := glow.ParseCircuit(patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b1, b2 bytes.Buffer
+		if err := c.WriteText(&b1); err != nil {
+			t.Fatal(err)
+		}
+		c2, err := glow.ParseCircuit(b1.String())
+		if err != nil {
+			t.Fatalf("%v in:\n%s", err, b1.String())
+		}
+		if err := c2.WriteText(&b2); err != nil {
+			t.Fatal(err)
+		}
+		if b1.String() != b2.String() {
+			t.Errorf("output differs:\n%s\n%s", b1.String(), b2.String())
+		}
+
+		out.Reset()
+		c.Feed(0, glow.Message{5})
+		expect := out.String()
+		out.Reset()
+		c2.Feed(0, glow.Message{5})
+		if out.String() != expect {
+			t.Errorf("expected %q, got: %q", expect, out.String())
+		}
+	}
+}
+
+func TestWriteTextErrors(t *testing.T) {
+	c := glow.NewCircuit()
+	c.Add(glow.NewGadget())
+	if err := c.WriteText(&bytes.Buffer{}); err == nil {
+		t.Error("expected error for gadget without name")
+	}
+	c = glow.NewCircuit()
+	c.Add(glow.LookupGadget("print", glow.Message{1, 2}))
+	if err := c.WriteText(&bytes.Buffer{}); err == nil {
+		t.Error("expected error for nested vector")
+	}
+}
@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+// newProbe puts a gadget in a new circuit, with a recorder on each outlet,
+// and returns the circuit and the log of "outlet:message" entries.
+func newProbe(name string, args ...interface{}) (*glow.Circuit, *[]string) {
+	log := []string{}
+	c := glow.NewCircuit()
+	g := glow.LookupGadget(name, args...)
+	c.Add(g)
+	for o := 0; o < g.NumOutlets(); o++ {
+		o := o
+		r := glow.NewGadget()
+		r.AddInlet(func(m glow.Message) {
+			log = append(log, fmt.Sprintf("%d:%s", o, m))
+		})
+		c.Add(r)
+		c.AddWire(0, o, o+1, 0)
+	}
+	return c, &log
+}
+
+// feed sends a number of messages to a gadget, each as (inlet, message).
+func feed(c *glow.Circuit, feeds ...interface{}) {
+	g := c.Gadgets()[0]
+	for i := 0; i < len(feeds); i += 2 {
+		g.Feed(feeds[i].(int), feeds[i+1].(glow.Message))
+	}
+}
+
+// expectLog compares the log with the expected entries.
+func expectLog(t *testing.T, log *[]string, expect string) {
+	t.Helper()
+	if s := fmt.Sprint(*log); s != expect {
+		t.Errorf("expected %s, got: %s", expect, s)
+	}
+	*log = (*log)[:0]
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestDebounceGadget(t *testing.T) {
+	c, log := newProbe("debounce", 100)
+	feed(c, 0, glow.Message{1})
+	c.Run(60)
+	feed(c, 0, glow.Message{2})
+	c.Run(60)
+	feed(c, 0, glow.Message{3})
+	c.Run(99)
+	expectLog(t, log, "[]")
+	c.Run(1)
+	expectLog(t, log, "[0:3]")
+
+	feed(c, 0, glow.Message{4}, 0, glow.Message{"stop"})
+	c.Run(200)
+	expectLog(t, log, "[]")
+	feed(c, 1, glow.Message{10}, 0, glow.Message{5})
+	c.Run(10)
+	expectLog(t, log, "[0:5]")
+
+	feed(c, 0, glow.Message{"stop", 1})
+	c.Run(10)
+	expectLog(t, log, "[0:stop 1]")
+}
+
+func TestThrottleGadget(t *testing.T) {
+	c, log := newProbe("throttle", 2, 100)
+	feed(c, 0, glow.Message{1}, 0, glow.Message{2}, 0, glow.Message{3})
+	expectLog(t, log, "[0:1 0:2 1:3]")
+	c.Run(99)
+	feed(c, 0, glow.Message{4})
+	expectLog(t, log, "[1:4]")
+	c.Run(1)
+	feed(c, 0, glow.Message{5}, 0, glow.Message{6}, 0, glow.Message{7})
+	expectLog(t, log, "[0:5 0:6 1:7]")
+
+	c, log = newProbe("throttle", 50)
+	feed(c, 0, glow.Message{1}, 0, glow.Message{2})
+	c.Run(50)
+	feed(c, 0, glow.Message{3})
+	expectLog(t, log, "[0:1 1:2 0:3]")
+}
+
+func TestSampleGadget(t *testing.T) {
+	c, log := newProbe("sample")
+	feed(c, 0, glow.Message{})
+	expectLog(t, log, "[]")
+	feed(c, 1, glow.Message{1}, 1, glow.Message{2}, 0, glow.Message{}, 0, glow.Message{})
+	expectLog(t, log, "[0:2 0:2]")
+	feed(c, 0, glow.Message{"clear"}, 0, glow.Message{})
+	expectLog(t, log, "[]")
+}
+
+func TestSampleOnMetro(t *testing.T) {
+	c, log := newProbe("sample")
+	c.Add(glow.LookupGadget("metro", 100))
+	c.AddWire(2, 0, 0, 0)
+	feed(c, 1, glow.Message{"a"})
+	c.Run(250)
+	expectLog(t, log, "[0:a 0:a]")
+}
+
+func TestDeadbandGadget(t *testing.T) {
+	c, log := newProbe("deadband", 1)
+	for _, v := range []float64{10, 10.5, 11, 11.2, 9.9, 9.5} {
+		feed(c, 0, glow.Message{v})
+	}
+	expectLog(t, log, "[0:10.0 0:11.2 0:9.9]")
+	feed(c, 0, glow.Message{}, 0, glow.Message{10}, 1, glow.Message{0}, 0, glow.Message{10})
+	expectLog(t, log, "[0:10]")
+}
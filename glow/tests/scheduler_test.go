@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+)
+
+func TestTimersFireInOrder(t *testing.T) {
+	c := glow.NewCircuit()
+	v := []int{}
+	for i, ms := range []int{50, 10, 50, 30, 10, 50, 20} {
+		i := i
+		c.SetTimer(ms, func() { v = append(v, i) })
+	}
+	c.Run(100)
+
+	// equal deadlines must fire in the order in which they were set
+	if fmt.Sprint(v) != "[1 4 6 3 0 2 5]" {
+		t.Error("expected '[1 4 6 3 0 2 5]', got:", fmt.Sprint(v))
+	}
+}
+
+func TestCancelManyTimers(t *testing.T) {
+	c := glow.NewCircuit()
+	count := 0
+	var timers []*glow.Timer
+	for i := 0; i < 1000; i++ {
+		timers = append(timers, c.SetTimer(1+i%97, func() { count++ }))
+	}
+	for i := 0; i < len(timers); i += 2 {
+		c.CancelTimer(timers[i])
+	}
+	c.CancelTimer(timers[0]) // cancelling twice is harmless
+	c.Run(100)
+
+	if count != 500 {
+		t.Error("expected 500, got:", count)
+	}
+	if c.NextTimer() >= 0 {
+		t.Error("there should be no timeouts pending")
+	}
+}
+
+func TestCancelFiredTimer(t *testing.T) {
+	c := glow.NewCircuit()
+	t1 := c.SetTimer(10, func() {})
+	c.SetTimer(20, func() {})
+	c.Run(15)
+	c.CancelTimer(t1) // must not affect the other timer
+
+	if c.NextTimer() != 20 {
+		t.Error("expected 20, got:", c.NextTimer())
+	}
+}
+
+func TestTimerCancelsAnother(t *testing.T) {
+	c := glow.NewCircuit()
+	fired := false
+	var t2 *glow.Timer
+	c.SetTimer(10, func() { c.CancelTimer(t2) })
+	t2 = c.SetTimer(10, func() { fired = true })
+	c.Run(20)
+
+	if fired {
+		t.Error("cancelled timer should not fire")
+	}
+}
+
+// fillTimers returns a circuit with n pending timers at pseudo-random times.
+func fillTimers(n int) *glow.Circuit {
+	c := glow.NewCircuit()
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		c.SetTimer(1+r.Intn(1000000), func() {})
+	}
+	return c
+}
+
+func BenchmarkSetAndCancelTimer(b *testing.B) {
+	for _, n := range []int{100, 10000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			c := fillTimers(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.CancelTimer(c.SetTimer(1+i%1000000, func() {}))
+			}
+		})
+	}
+}
+
+func BenchmarkFireTimer(b *testing.B) {
+	for _, n := range []int{100, 10000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			c := fillTimers(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.SetTimer(0, func() {})
+				c.Run(0) // fires only the timer which was just set
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+// newSender returns a circuit with an inlet wired to a send gadget.
+func newSender(args ...interface{}) *glow.Circuit {
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("inlet"))
+	c.Add(glow.LookupGadget("s", args...))
+	c.AddWire(0, 0, 1, 0)
+	return c
+}
+
+// newReceiver returns a circuit with a receive gadget wired to print.
+func newReceiver(args ...interface{}) *glow.Circuit {
+	c := glow.NewCircuit()
+	c.Add(glow.LookupGadget("r", args...))
+	c.Add(glow.LookupGadget("print", "r"))
+	c.AddWire(0, 0, 1, 0)
+	return c
+}
+
+func TestSendScopes(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	for _, scope := range []string{"global", "local", "up"} {
+		b.Reset()
+		c := glow.NewCircuit()
+		c.Add(glow.LookupGadget("r", "foo"))
+		c.Add(glow.LookupGadget("print", "outer"))
+		c.AddWire(0, 0, 1, 0)
+		sender := newSender("foo", scope)
+		c.Add(sender)
+		sibling := newReceiver("foo", "local")
+		c.Add(sibling)
+
+		sender.Feed(0, glow.Message{1})
+
+		expect := map[string]string{
+			"global": "outer 1\n",
+			"local":  "",
+			"up":     "outer 1\n",
+		}[scope]
+		if b.String() != expect {
+			t.Errorf("%s: expected %q, got: %q", scope, expect, b)
+		}
+	}
+}
+
+func TestGlobalSendToSibling(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	sender := newSender("foo")
+	receiver := newReceiver("foo") // subscribed before being nested
+	c := glow.NewCircuit()
+	c.Add(sender)
+	c.Add(receiver)
+
+	sender.Feed(0, glow.Message{1})
+	c.RemoveGadget(1)
+	sender.Feed(0, glow.Message{2})
+
+	if b.String() != "r 1\n" {
+		t.Errorf("expected 1 line, got: %q", b)
+	}
+}
+
+func TestDollarZeroNames(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := glow.NewCircuit()
+	for i := 0; i < 2; i++ {
+		sub := newReceiver("$0-foo")
+		sub.Add(glow.LookupGadget("inlet"))
+		sub.Add(glow.LookupGadget("s", "$0-foo"))
+		sub.AddWire(2, 0, 3, 0)
+		c.Add(sub)
+	}
+	subs := c.Gadgets()
+	subs[1].Feed(0, glow.Message{1})
+
+	if b.String() != "r 1\n" {
+		t.Errorf("expected 1 line, got: %q", b)
+	}
+	c1, c2 := subs[0].(*glow.Circuit), subs[1].(*glow.Circuit)
+	if c1.Expand("$0-foo") == c2.Expand("$0-foo") {
+		t.Error("$0 should differ per circuit:", c1.Expand("$0-foo"))
+	}
+}
+
+func TestDollarZeroInSubpatch(t *testing.T) {
+	tmp, path := glow.Debug, glow.DesignPath
+	defer func() { glow.Debug, glow.DesignPath = tmp, path }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+	glow.DesignPath = []string{"testdata"}
+
+	text := "#N canvas 0 50 450 300 10;\n" +
+		"#X obj 10 10 relay a;\n" +
+		"#X obj 10 40 relay b;\n"
+	c, err := glow.ParseCircuit(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Gadgets()[0].Feed(0, glow.Message{1})
+	c.Gadgets()[1].Feed(0, glow.Message{2})
+
+	if b.String() != "a 1\nb 2\n" {
+		t.Errorf("expected 2 lines, got: %q", b)
+	}
+}
+
+func TestReceiveAfterClose(t *testing.T) {
+	tmp := glow.Debug
+	defer func() { glow.Debug = tmp }()
+	b := &bytes.Buffer{}
+	glow.Debug = b
+
+	c := newReceiver("foo")
+	c.Stop()
+	c.Send("foo", glow.Global, glow.Message{1})
+	c.Start()
+	c.Send("foo", glow.Global, glow.Message{2})
+	c.Close()
+	c.Send("foo", glow.Global, glow.Message{3})
+
+	if b.String() != "r 2\n" {
+		t.Errorf("expected 1 line, got: %q", b)
+	}
+}
+
+func TestSendOutsideCircuit(t *testing.T) {
+	g := glow.LookupGadget("s", "foo")
+	g.Feed(0, glow.Message{1}) // dropped, no panic
+	c := glow.NewCircuit()
+	c.Add(g)
+	c.RemoveGadget(0)
+	g.Feed(0, glow.Message{2})
+}
+
+func TestParseScope(t *testing.T) {
+	for _, s := range []string{"global", "local", "up"} {
+		scope, err := glow.ParseScope(s)
+		if err != nil || scope.String() != s {
+			t.Error("expected", s, "got:", scope, err)
+		}
+	}
+	if _, err := glow.ParseScope("nope"); err == nil {
+		t.Error("expected an error")
+	}
+	if s := glow.Scope(7).String(); s != "Scope(7)" {
+		t.Error("expected a fallback, got:", s)
+	}
+}
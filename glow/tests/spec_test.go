@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestSpecAlias(t *testing.T) {
+	s := glow.LookupSpec("t")
+	if s == nil || s.Name != "trigger" {
+		t.Fatalf("expected trigger spec, got: %v", s)
+	}
+	if glow.LookupSpec("blah") != nil {
+		t.Errorf("expected no spec for blah")
+	}
+}
+
+func TestSpecsSorted(t *testing.T) {
+	specs := glow.Specs()
+	seen := map[string]bool{}
+	for i, s := range specs {
+		if i > 0 && specs[i-1].Name >= s.Name {
+			t.Errorf("not sorted: %s >= %s", specs[i-1].Name, s.Name)
+		}
+		seen[s.Name] = true
+	}
+	if !seen["send"] || seen["s"] {
+		t.Errorf("expected send but not its alias s")
+	}
+}
+
+func TestSpecsMatchGadgets(t *testing.T) {
+	for _, s := range glow.Specs() {
+		if s.Doc == "" {
+			t.Errorf("%s: no doc", s.Name)
+		}
+		if s.Variadic {
+			continue
+		}
+		g := glow.Registry[s.Name](nil).(*glow.Gadget)
+		c := glow.NewCircuit()
+		c.Stop()
+		c.Add(g) // inlet and outlet are only set up once added
+		if g.NumInlets() != len(s.Inlets) {
+			t.Errorf("%s: %d inlets, spec has %d",
+				s.Name, g.NumInlets(), len(s.Inlets))
+		}
+		if g.NumOutlets() != len(s.Outlets) {
+			t.Errorf("%s: %d outlets, spec has %d",
+				s.Name, g.NumOutlets(), len(s.Outlets))
+		}
+		for i, p := range s.Inlets {
+			if i < g.NumInlets() && g.IsCold(i) != p.Cold {
+				t.Errorf("%s: inlet %d cold is %v", s.Name, i, g.IsCold(i))
+			}
+		}
+	}
+}
+
+func TestSpecJSON(t *testing.T) {
+	data, err := json.Marshal(glow.LookupSpec("moses"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(data)
+	for _, e := range []string{`"name":"moses"`, `"cold":true`, `"outlets":[`} {
+		if !strings.Contains(s, e) {
+			t.Errorf("expected %s in: %s", e, s)
+		}
+	}
+	if strings.Contains(s, "aliases") || strings.Contains(s, "New") {
+		t.Errorf("unexpected field in: %s", s)
+	}
+}
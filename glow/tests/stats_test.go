@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/jeelabs/jet/glow"
+	_ "github.com/jeelabs/jet/glow/gadgets"
+)
+
+func TestStatsTumbling(t *testing.T) {
+	c, log := newProbe("stats", 4, "n", "min", "max", "mean", "median", "stddev")
+	feed(c, 0, glow.Message{2}, 0, glow.Message{4}, 0, glow.Message{4})
+	expectLog(t, log, "[]")
+	feed(c, 0, glow.Message{6})
+	expectLog(t, log, "[5:1.4142135623730951 4:4.0 3:4.0 2:6.0 1:2.0 0:4]")
+	feed(c, 0, glow.Message{1}, 0, glow.Message{})
+	expectLog(t, log, "[5:0.0 4:1.0 3:1.0 2:1.0 1:1.0 0:1]")
+	feed(c, 0, glow.Message{"clear"}, 0, glow.Message{})
+	expectLog(t, log, "[]")
+}
+
+func TestStatsSliding(t *testing.T) {
+	c, log := newProbe("stats", 3, "sliding", "mean", "p100")
+	feed(c, 0, glow.Message{1}, 0, glow.Message{2})
+	expectLog(t, log, "[]")
+	feed(c, 0, glow.Message{3}, 0, glow.Message{7}, 0, glow.Message{"x"})
+	expectLog(t, log, "[1:3.0 0:2.0 1:7.0 0:4.0]")
+}
+
+func TestStatsPercentile(t *testing.T) {
+	c, log := newProbe("stats", 5, "p25", "p90")
+	feed(c, 0, glow.Message{50}, 0, glow.Message{10}, 0, glow.Message{40},
+		0, glow.Message{20}, 0, glow.Message{30})
+	expectLog(t, log, "[1:46.0 0:20.0]")
+}
+
+func TestStatsTimeWindow(t *testing.T) {
+	c, log := newProbe("stats", 100, "ms", "n", "mean")
+	feed(c, 0, glow.Message{1})
+	c.Run(50)
+	feed(c, 0, glow.Message{2.5})
+	c.Run(49)
+	expectLog(t, log, "[]")
+	c.Run(1)
+	expectLog(t, log, "[1:1.75 0:2]")
+	c.Run(200)
+	expectLog(t, log, "[]")
+}
+
+func TestStatsSlidingTime(t *testing.T) {
+	c, log := newProbe("stats", 100, "ms", "sliding", "max")
+	feed(c, 0, glow.Message{5})
+	c.Run(60)
+	feed(c, 0, glow.Message{3})
+	c.Run(40)
+	feed(c, 0, glow.Message{1})
+	expectLog(t, log, "[0:5.0 0:5.0 0:3.0]")
+}
+
+func TestStatsBadSize(t *testing.T) {
+	c, log := newProbe("stats", 3, "sliding", "max")
+	feed(c, 1, glow.Message{-1}, 1, glow.Message{0}, 0, glow.Message{1},
+		0, glow.Message{2}, 0, glow.Message{3})
+	expectLog(t, log, "[0:3.0]")
+}
+
+func TestStatsErrors(t *testing.T) {
+	for _, args := range [][]interface{}{
+		{}, {0}, {10, "foo"}, {10, "p101"}, {10, "p"},
+	} {
+		g := glow.LookupGadget("stats", args...)
+		if g.(*glow.Gadget).Err() == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
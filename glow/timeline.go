@@ -0,0 +1,192 @@
+package glow
+
+import (
+	"context"
+	"sync"
+)
+
+// A timeline keeps track of the time and all pending timers of a top-level
+// circuit. Timers can be set and cancelled from any goroutine, their callbacks
+// are posted to the event loop of the circuit when they fire.
+type timeline struct {
+	mu     sync.Mutex
+	clock  Clock
+	timers scheduler
+	wake   chan struct{} // signalled when timers change while sleeping
+}
+
+// setup prepares a timeline for use, with a simulated clock.
+func (tl *timeline) setup() {
+	tl.clock = NewSimClock()
+	tl.wake = make(chan struct{}, 1)
+}
+
+// add schedules a timer at the specified time, the lock must be held.
+func (tl *timeline) add(t *Timer, due int) {
+	if next := tl.timers.next(); due < next || next < 0 {
+		select {
+		case tl.wake <- struct{}{}:
+		default: // already signalled
+		}
+	}
+	t.tl = tl
+	tl.timers.add(t, due)
+}
+
+// moveTo transfers all pending timers to another timeline, keeping the same
+// remaining time for each of them.
+func (tl *timeline) moveTo(dst *timeline) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	offset := dst.clock.Now() - tl.clock.Now()
+	for tl.timers.next() >= 0 {
+		t := tl.timers.pop()
+		dst.add(t, t.due+offset)
+	}
+}
+
+// run processes timers until the time limit has been reached, or the context
+// is done. There is no time limit if tlimit is negative.
+func (tl *timeline) run(ctx context.Context, tlimit int, post func(func())) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	for ctx.Err() == nil {
+		next := tl.timers.next()
+		if tlimit >= 0 && (next < 0 || next > tlimit) {
+			next = tlimit
+		}
+		if next < 0 || tl.clock.Now() < next {
+			clock, wake := tl.clock, tl.wake
+			tl.mu.Unlock()
+			clock.Sleep(ctx, next, wake) // this is where time advances
+			tl.mu.Lock()
+			continue
+		}
+		if next != tl.timers.next() {
+			return // the time limit has been reached
+		}
+		t := tl.timers.pop()
+		if t.period > 0 {
+			tl.add(t, t.due+t.period) // same timer, so it can be cancelled
+		}
+		tl.mu.Unlock()
+		post(t.callback) // this may set and cancel timers
+		tl.mu.Lock()
+	}
+}
+
+// Now returns the current time of the circuit, in milliseconds.
+func (c *Circuit) Now() int {
+	tl := &c.Root().timeline
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return tl.clock.Now()
+}
+
+// NextTimer returns the time when the first pending timer is due, else -1.
+func (c *Circuit) NextTimer() int {
+	tl := &c.Root().timeline
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return tl.timers.next()
+}
+
+// SetClock changes the source of time, e.g. to run timers in real time. This
+// should be done before any timers have been set.
+func (c *Circuit) SetClock(clock Clock) {
+	tl := &c.Root().timeline
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.clock = clock
+}
+
+// SetTimer schedules a one-shot notification.
+func (c *Circuit) SetTimer(ms int, f func()) *Timer {
+	return c.newTimer(ms, 0, f)
+}
+
+// SetPeriodic schedules a repeating notification.
+func (c *Circuit) SetPeriodic(ms int, f func()) *Timer {
+	return c.newTimer(ms, ms, f)
+}
+
+// newTimer sets up a timer, with a repeat interval if period is non-zero.
+func (c *Circuit) newTimer(ms, period int, f func()) *Timer {
+	t := &Timer{callback: f, period: period}
+	tl := &c.Root().timeline
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.add(t, tl.clock.Now()+ms)
+	return t
+}
+
+// CancelTimer drops a pending timer notification.
+func (c *Circuit) CancelTimer(t *Timer) {
+	cancelTimer(t)
+}
+
+// cancelTimer drops a timer from whichever timeline it is on, if any.
+func cancelTimer(t *Timer) {
+	if t == nil || t.tl == nil {
+		return
+	}
+	tl := t.tl
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.timers.remove(t)
+}
+
+// Now returns the current time of the circuit this gadget is in. There is no
+// time outside a circuit, it is always 0 there.
+func (g *Gadget) Now() int {
+	if g.parent == nil {
+		return 0
+	}
+	return g.parent.Now()
+}
+
+// SetTimer schedules a one-shot notification in the circuit this gadget is in.
+// Outside a circuit it does nothing, and returns nil.
+func (g *Gadget) SetTimer(ms int, f func()) *Timer {
+	if g.parent == nil {
+		return nil
+	}
+	return g.parent.SetTimer(ms, f)
+}
+
+// SetPeriodic schedules a repeating notification in the circuit this gadget
+// is in. Outside a circuit it does nothing, and returns nil.
+func (g *Gadget) SetPeriodic(ms int, f func()) *Timer {
+	if g.parent == nil {
+		return nil
+	}
+	return g.parent.SetPeriodic(ms, f)
+}
+
+// CancelTimer drops a pending timer notification, also when this gadget is no
+// longer in the circuit where it was set.
+func (g *Gadget) CancelTimer(t *Timer) {
+	cancelTimer(t)
+}
+
+// Run advances (real or simulated) time and triggers all timers as scheduled.
+func (c *Circuit) Run(ms int) {
+	tl := &c.Root().timeline
+	tl.mu.Lock()
+	tlimit := tl.clock.Now() + ms
+	tl.mu.Unlock()
+	tl.run(context.Background(), tlimit, c.Post)
+}
+
+// RunUntil keeps triggering timers as scheduled, until the context is done.
+// When there are no pending timers, it waits for new ones to be set.
+func (c *Circuit) RunUntil(ctx context.Context) {
+	c.Root().timeline.run(ctx, -1, c.Post)
+}
+
+// RunForever keeps triggering timers as scheduled, it never returns.
+func (c *Circuit) RunForever() {
+	c.RunUntil(context.Background())
+}